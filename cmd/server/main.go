@@ -43,18 +43,23 @@ func main() {
 	go metrics.Start()
 	// Initialize services
 	lineItemService := service.NewLineItemService(log)
-	KafkaConfig := config.KafkaConfigLoad()
-	pubSub := service.NewPubSub(log, cfg)
-	errPubSub := pubSub.Connect(KafkaConfig)
-	if errPubSub != nil {
-		panic(fmt.Sprintf("Failed to connect to Kafka: %v", errPubSub))
+	pubSub, err := service.NewEventPublisher(log, cfg)
+	if err != nil {
+		log.Fatalw("Failed to set up event publisher", "error", err)
 	}
 
 	generator := service.NewDataGenerator(log, lineItemService)
 	generator.GenerateLineItems()
 	runTimeDBService := service.NewRunTimeDB(log)
-	advertisementService := service.NewAdService(log, runTimeDBService, lineItemService)
-	dataProcessorService := service.NewDataProcessorService(log, runTimeDBService, lineItemService)
+	normalizer := service.NewNormalizer(log)
+	ctrEstimator := service.NewCTREstimator(log, cfg)
+	experimentService := service.NewExperimentService(log)
+	tokenSigner := service.NewTokenSigner(log, cfg)
+	auctionStore := service.NewAuctionStore(log, cfg)
+	go auctionStore.Start()
+	deduplicator := service.NewDeduplicator(log, cfg)
+	advertisementService := service.NewAdService(log, cfg, runTimeDBService, lineItemService, normalizer, ctrEstimator, experimentService, tokenSigner, auctionStore)
+	dataProcessorService := service.NewDataProcessorService(log, runTimeDBService, lineItemService, normalizer)
 	onload := service.NewOnloadService(log, dataProcessorService)
 	onload.Start()
 	
@@ -83,11 +88,36 @@ func main() {
 	api.Get("/lineitems", lineItemHandler.GetAll)
 	api.Get("/lineitems/:id", lineItemHandler.GetByID)
 
-	adHandler := handler.NewAdHandler(log, advertisementService)
+	adHandler := handler.NewAdHandler(log, cfg, advertisementService)
 	api.Get("/ads", adHandler.GetWinningAds)
-
-	trackingHandler := handler.NewTrackingHandler(log, pubSub)
+	api.Get("/vast", adHandler.GetVAST)
+	// colon is a route parameter prefix in fiber, it has to be escaped to be matched literally
+	api.Post("/ads\\:batch", adHandler.GetBatchAds)
+
+	synonymHandler := handler.NewSynonymHandler(log, normalizer)
+	api.Get("/synonyms", synonymHandler.GetAll)
+	api.Put("/synonyms/:term", synonymHandler.Set)
+	api.Delete("/synonyms/:term", synonymHandler.Delete)
+
+	experimentHandler := handler.NewExperimentHandler(log, experimentService)
+	api.Post("/experiments", experimentHandler.Create)
+	api.Get("/experiments", experimentHandler.GetAll)
+	api.Get("/experiments/:id", experimentHandler.GetByID)
+	api.Post("/experiments/:id/stop", experimentHandler.Stop)
+
+	trackingHandler := handler.NewTrackingHandler(log, cfg, pubSub, lineItemService, ctrEstimator, tokenSigner, auctionStore, deduplicator)
 	api.Post("/tracking", trackingHandler.TrackEvent)
+	api.Get("/tracking", trackingHandler.TrackURL)
+	api.Post("/tracking\\:batch", trackingHandler.TrackBatch)
+	// signed pixel and click URLs embedded in every served ad, short paths outside /api/v1 as they end up in ad markup
+	app.Get("/t/imp.gif", trackingHandler.ImpressionPixel)
+	app.Get("/t/click", trackingHandler.ClickRedirect)
+	app.Get("/t/event", trackingHandler.TrackSignedEvent)
+
+	// OpenRTB lives outside /api/v1, /openrtb2/... is the path exchanges and Prebid Server expect
+	openRTBHandler := handler.NewOpenRTBHandler(log, advertisementService, tokenSigner)
+	app.Post("/openrtb2/bid", openRTBHandler.Bid)
+	app.Get("/openrtb2/win", openRTBHandler.WinNotice)
 
 	// Start server
 	go func() {
@@ -107,6 +137,8 @@ func main() {
 	if err := app.Shutdown(); err != nil {
 		log.Fatalf("Error shutting down server: %v", err)
 	}
+	// no more requests, flush the queued tracking events
+	pubSub.Close()
 
 	log.Info("Server gracefully stopped")
 }
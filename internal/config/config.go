@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"github.com/IBM/sarama"
 	"time"
 
@@ -9,10 +10,17 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	App     AppConfig     `split_words:"true"`
-	Server  ServerConfig  `split_words:"true"`
-	PubSub  PubSubConfig  `split_words:"true"`
-	Metrics MetricsConfig `split_words:"true"`
+	App      AppConfig      `split_words:"true"`
+	Server   ServerConfig   `split_words:"true"`
+	PubSub   PubSubConfig   `split_words:"true"`
+	Metrics  MetricsConfig  `split_words:"true"`
+	Matching MatchingConfig `split_words:"true"`
+	Auction  AuctionConfig  `split_words:"true"`
+	Debug    DebugConfig    `split_words:"true"`
+	Ranking  RankingConfig  `split_words:"true"`
+	// Exploration hands a share of the requests to under-served line items
+	Exploration ExplorationConfig `split_words:"true"`
+	Tracking    TrackingConfig    `split_words:"true"`
 }
 
 // AppConfig contains application-specific configuration
@@ -27,28 +35,118 @@ type AppConfig struct {
 type ServerConfig struct {
 	Port    int           `default:"8080"`
 	Timeout time.Duration `default:"30s"`
+	// PublicURL is how exchanges and browsers reach this server, used in win notice and tracking URLs
+	PublicURL string `default:"http://localhost:8080" split_words:"true"`
 }
 
-// PubSubConfig contains connection requirement
+// PubSubConfig contains connection requirement. Backend is where tracking events go: kafka, memory (tests) or
+// file (newline-delimited File, for local runs without a broker), Encoding is json or protobuf (api/tracking_event.proto).
+// Messages are keyed by MessageKey (line_item, user or none) and Partitioner places the keys on the partitions:
+// murmur2 (like the Java client), hash, crc32, random or round_robin.
+// The rest only applies to kafka. Events wait in a queue of QueueSize messages for the async producer,
+// which sends a batch every Linger or as soon as it has BatchSize messages (BatchBytes bytes).
+// Backpressure is what happens when the queue is full: drop the event, block the request until there is room,
+// or reject it with 503. With a SpoolDir (empty disables it) messages go to the disk spool instead whenever kafka is
+// unreachable or the queue is full, backpressure only applies once the spool reached SpoolMaxBytes.
+// The spool is replayed and the broker reconnected every SpoolRetryInterval
 type PubSubConfig struct {
-	Broker        string `default:"kafka:9092"`
-	Topic         string `default:"tracking-events"`
-	RetryMax      int    `default:"3"`
-	ReturnSuccess bool   `default:"true"`
+	Backend            string                  `default:"kafka"`
+	File               string                  `default:"tracking-events.ndjson"`
+	Encoding           string                  `default:"json"`
+	MessageKey         string                  `default:"line_item" split_words:"true"`
+	Partitioner        string                  `default:"murmur2"`
+	Broker             string                  `default:"kafka:9092"`
+	Topic              string                  `default:"tracking-events"`
+	RetryMax           int                     `default:"3"`
+	ReturnSuccess      bool                    `default:"true"`
+	QueueSize          int                     `default:"10000" split_words:"true"`
+	Backpressure       string                  `default:"drop"`
+	Linger             time.Duration           `default:"10ms"`
+	BatchSize          int                     `default:"500" split_words:"true"`
+	BatchBytes         int                     `default:"1048576" split_words:"true"`
+	Compression        sarama.CompressionCodec `default:"snappy"` // none, gzip, snappy, lz4 or zstd
+	SpoolDir           string                  `default:"spool" split_words:"true"`
+	SpoolSegmentBytes  int64                   `default:"67108864" split_words:"true"`
+	SpoolMaxBytes      int64                   `default:"1073741824" split_words:"true"`
+	SpoolRetryInterval time.Duration           `default:"5s" split_words:"true"`
 }
 
 type MetricsConfig struct {
 	Port int `default:"9100"`
 }
 
+// MatchingConfig controls partial (prefix and fuzzy) keyword matching, placements listed here get it for every line item
+type MatchingConfig struct {
+	PrefixPlacements []string `split_words:"true"`
+	FuzzyPlacements  []string `split_words:"true"`
+	PrefixMinLength  int      `default:"3" split_words:"true"`
+	FuzzyMinLength   int      `default:"4" split_words:"true"`
+	FuzzyMaxDistance int      `default:"1" split_words:"true"`
+}
+
+// AuctionConfig controls how the winning ads are priced
+type AuctionConfig struct {
+	Type           string  `default:"first_price"` // first_price, second_price or gsp
+	PriceIncrement float64 `default:"0.01" split_words:"true"`
+	ReservePrice   float64 `default:"0.1" split_words:"true"`
+	// Floors are "key:price" pairs, e.g. APP_AUCTION_PLACEMENT_FLOORS=homepage_top:2.0,footer_banner:0.5
+	PlacementFloors map[string]float64 `split_words:"true"`
+	CategoryFloors  map[string]float64 `split_words:"true"`
+	// 0 means one advertiser can take every slot of the response
+	MaxAdsPerAdvertiser int `default:"0" split_words:"true"`
+}
+
+// RankingConfig selects how candidates are ranked per placement ("placement:mode" pairs, relevance or ecpm),
+// placements not listed use relevance. Prior is the Beta prior of the CTR estimator used by ecpm
+type RankingConfig struct {
+	Modes       map[string]string
+	PriorCTR    float64 `default:"0.01" split_words:"true"`
+	PriorWeight float64 `default:"100" split_words:"true"`
+}
+
+// ExplorationConfig policy is none, epsilon_greedy or thompson. Share is the part of the requests (0-1) whose last
+// slot goes to a line item with fewer than MinImpressions impressions on the placement
+type ExplorationConfig struct {
+	Policy         string  `default:"none"`
+	Share          float64 `default:"0.05"`
+	MinImpressions float64 `default:"1000" split_words:"true"`
+}
+
+// DebugConfig protects the auction explain output (?debug=true), an empty token disables it completely
+type DebugConfig struct {
+	Token string
+}
+
+// TrackingConfig signs the impression pixel, click and OpenRTB win notice URLs, tokens are valid for TokenTTL after the auction.
+// A click hands the landing page a conversion token valid for ConversionTokenTTL, conversions sent with it are attributed to the ad.
+// Served auctions are remembered for AuctionTTL to check the auction_id of tracking events, RequireAuctionID rejects events without one.
+// Retried events are dropped for at least DedupWindow (0 disables it), the filters are sized for DedupCapacity events per window.
+// MaxBatchSize caps the events of one POST /api/v1/tracking:batch
+type TrackingConfig struct {
+	Secret                 string
+	TokenTTL               time.Duration `default:"24h" split_words:"true"`
+	ConversionTokenTTL     time.Duration `default:"720h" split_words:"true"`
+	AuctionTTL             time.Duration `default:"30m" split_words:"true"`
+	RequireAuctionID       bool          `split_words:"true"`
+	DedupWindow            time.Duration `default:"10m" split_words:"true"`
+	DedupCapacity          int           `default:"1000000" split_words:"true"`
+	DedupFalsePositiveRate float64       `default:"0.001" split_words:"true"`
+	MaxBatchSize           int           `default:"1000" split_words:"true"`
+}
+
 //Kafka config spin up
 
-func KafkaConfigLoad() *sarama.Config {
-	p := PubSubConfig{}
+func KafkaConfigLoad(cfg *Config) *sarama.Config {
+	p := cfg.PubSub
 	KafkaConfig := sarama.NewConfig()
 	KafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
 	KafkaConfig.Producer.Retry.Max = p.RetryMax
-	KafkaConfig.Producer.Return.Successes = true
+	KafkaConfig.Producer.Return.Successes = p.ReturnSuccess
+	KafkaConfig.Producer.Return.Errors = true
+	KafkaConfig.Producer.Flush.Frequency = p.Linger
+	KafkaConfig.Producer.Flush.Messages = p.BatchSize
+	KafkaConfig.Producer.Flush.Bytes = p.BatchBytes
+	KafkaConfig.Producer.Compression = p.Compression
 	return KafkaConfig
 }
 
@@ -58,5 +156,67 @@ func Load() (*Config, error) {
 	if err := envconfig.Process("app", &config); err != nil {
 		return nil, err
 	}
+	switch config.Auction.Type {
+	case "first_price", "second_price", "gsp":
+	default:
+		return nil, fmt.Errorf("unknown auction type %q, expected first_price, second_price or gsp", config.Auction.Type)
+	}
+	switch config.Exploration.Policy {
+	case "none", "epsilon_greedy", "thompson":
+	default:
+		return nil, fmt.Errorf("unknown exploration policy %q, expected none, epsilon_greedy or thompson", config.Exploration.Policy)
+	}
+	if config.Exploration.Share < 0 || config.Exploration.Share > 1 {
+		return nil, fmt.Errorf("exploration share has to be between 0 and 1, got %g", config.Exploration.Share)
+	}
+	switch config.PubSub.Backend {
+	case "kafka", "memory", "file":
+	default:
+		return nil, fmt.Errorf("unknown pubsub backend %q, expected kafka, memory or file", config.PubSub.Backend)
+	}
+	if config.PubSub.Encoding != "json" && config.PubSub.Encoding != "protobuf" {
+		return nil, fmt.Errorf("unknown pubsub encoding %q, expected json or protobuf", config.PubSub.Encoding)
+	}
+	switch config.PubSub.MessageKey {
+	case "line_item", "user", "none":
+	default:
+		return nil, fmt.Errorf("unknown pubsub message key %q, expected line_item, user or none", config.PubSub.MessageKey)
+	}
+	switch config.PubSub.Partitioner {
+	case "murmur2", "hash", "crc32", "random", "round_robin":
+	default:
+		return nil, fmt.Errorf("unknown pubsub partitioner %q, expected murmur2, hash, crc32, random or round_robin", config.PubSub.Partitioner)
+	}
+	switch config.PubSub.Backpressure {
+	case "drop", "block", "reject":
+	default:
+		return nil, fmt.Errorf("unknown pubsub backpressure %q, expected drop, block or reject", config.PubSub.Backpressure)
+	}
+	if config.PubSub.QueueSize < 1 {
+		return nil, fmt.Errorf("pubsub queue size has to be positive, got %d", config.PubSub.QueueSize)
+	}
+	if config.PubSub.SpoolDir != "" && (config.PubSub.SpoolSegmentBytes < 1 || config.PubSub.SpoolRetryInterval <= 0) {
+		return nil, fmt.Errorf("pubsub spool segment bytes and retry interval have to be positive")
+	}
+	if config.Tracking.DedupCapacity < 1 {
+		return nil, fmt.Errorf("tracking dedup capacity has to be positive, got %d", config.Tracking.DedupCapacity)
+	}
+	if config.Tracking.DedupFalsePositiveRate <= 0 || config.Tracking.DedupFalsePositiveRate >= 1 {
+		return nil, fmt.Errorf("tracking dedup false positive rate has to be between 0 and 1, got %g", config.Tracking.DedupFalsePositiveRate)
+	}
+	if config.Tracking.MaxBatchSize < 1 {
+		return nil, fmt.Errorf("tracking max batch size has to be positive, got %d", config.Tracking.MaxBatchSize)
+	}
+	if config.Ranking.PriorCTR <= 0 || config.Ranking.PriorCTR >= 1 {
+		return nil, fmt.Errorf("ranking prior CTR has to be between 0 and 1, got %g", config.Ranking.PriorCTR)
+	}
+	if config.Ranking.PriorWeight <= 0 {
+		return nil, fmt.Errorf("ranking prior weight has to be positive, got %g", config.Ranking.PriorWeight)
+	}
+	for placement, mode := range config.Ranking.Modes {
+		if mode != "relevance" && mode != "ecpm" {
+			return nil, fmt.Errorf("unknown ranking mode %q for placement %s, expected relevance or ecpm", mode, placement)
+		}
+	}
 	return &config, nil
 }
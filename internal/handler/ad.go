@@ -1,23 +1,29 @@
 package handler
 
 import (
+	"crypto/subtle"
+	"strings"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
+	"sweng-task/internal/config"
 	"sweng-task/internal/model"
 	"sweng-task/internal/service"
 )
 
 type AdHandler struct {
 	logs *zap.SugaredLogger
+	cfg  *config.Config
 	ad   *service.AdService
 }
 
 var validate = validator.New()
 
-func NewAdHandler(log *zap.SugaredLogger, adv *service.AdService) *AdHandler {
+func NewAdHandler(log *zap.SugaredLogger, cfg *config.Config, adv *service.AdService) *AdHandler {
 	return &AdHandler{
 		logs: log,
+		cfg:  cfg,
 		ad:   adv,
 	}
 }
@@ -33,6 +39,11 @@ func (a *AdHandler) GetWinningAds(c *fiber.Ctx) error {
 		})
 	}
 
+	// ISO codes are uppercase, but nobody should get a 400 for sending "us"
+	query.Country = strings.ToUpper(query.Country)
+	query.Region = strings.ToUpper(query.Region)
+	query.Device = service.ParseUserAgent(c.Get(fiber.HeaderUserAgent))
+
 	if err := validate.Struct(query); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"code":    fiber.StatusBadRequest,
@@ -41,7 +52,11 @@ func (a *AdHandler) GetWinningAds(c *fiber.Ctx) error {
 		})
 	}
 
-	advertisements, err := a.ad.GetAd(query.Placement, query.Keyword, query.Category, query.Limit)
+	if query.Debug {
+		return a.explain(c, query)
+	}
+
+	advertisements, err := a.ad.GetAd(query)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"code":    fiber.StatusNotFound,
@@ -51,3 +66,98 @@ func (a *AdHandler) GetWinningAds(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(advertisements)
 }
+
+// GetVAST returns the winning video ads as a VAST 4 document, an empty VAST when nothing can play on the placement
+func (a *AdHandler) GetVAST(c *fiber.Ctx) error {
+	var query model.WinningAdsQuery
+
+	if err := c.QueryParser(&query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Failed to parse query parameters",
+			"details": err.Error(),
+		})
+	}
+
+	query.Country = strings.ToUpper(query.Country)
+	query.Region = strings.ToUpper(query.Region)
+	query.Device = service.ParseUserAgent(c.Get(fiber.HeaderUserAgent))
+
+	if err := validate.Struct(query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid query parameters",
+			"details": err.Error(),
+		})
+	}
+
+	body, err := service.MarshalVAST(a.ad.GetVAST(query))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code":    fiber.StatusInternalServerError,
+			"message": "Failed to render VAST",
+			"details": err.Error(),
+		})
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+	return c.Status(fiber.StatusOK).Send(body)
+}
+
+// GetBatchAds fills all the slots of a page in one coordinated auction, the same ad never shows up twice on the page
+func (a *AdHandler) GetBatchAds(c *fiber.Ctx) error {
+	var request model.BatchAdsRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	request.Context.Country = strings.ToUpper(request.Context.Country)
+	request.Context.Region = strings.ToUpper(request.Context.Region)
+
+	if err := validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	slots := a.ad.GetAdsBatch(request, service.ParseUserAgent(c.Get(fiber.HeaderUserAgent)))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"slots": slots})
+}
+
+// explain returns the winning ads together with the auction internals, only for callers holding the debug token
+func (a *AdHandler) explain(c *fiber.Ctx, query model.WinningAdsQuery) error {
+	if !a.debugAuthorized(c) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"code":    fiber.StatusForbidden,
+			"message": "Debug mode is not allowed for this caller",
+		})
+	}
+
+	advertisements, debug, err := a.ad.Explain(query)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"code":    fiber.StatusNotFound,
+			"message": "No matching ads found",
+		})
+	}
+	a.logs.Infow("Auction explained", "placement", query.Placement, "candidates", len(debug.Candidates))
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"ads":   advertisements,
+		"debug": debug,
+	})
+}
+
+func (a *AdHandler) debugAuthorized(c *fiber.Ctx) bool {
+	token := a.cfg.Debug.Token
+	if token == "" {
+		return false
+	}
+	provided := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
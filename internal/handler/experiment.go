@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"errors"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// ExperimentHandler handles HTTP requests related to ad selection experiments
+type ExperimentHandler struct {
+	logs        *zap.SugaredLogger
+	experiments *service.ExperimentService
+}
+
+// NewExperimentHandler creates a new ExperimentHandler
+func NewExperimentHandler(log *zap.SugaredLogger, experiments *service.ExperimentService) *ExperimentHandler {
+	return &ExperimentHandler{
+		logs:        log,
+		experiments: experiments,
+	}
+}
+
+// Create starts a new experiment
+func (h *ExperimentHandler) Create(c *fiber.Ctx) error {
+	var input model.ExperimentCreate
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	if err := validate.Struct(input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	experiment, err := h.experiments.Create(input)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidExperiment) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"code":    fiber.StatusBadRequest,
+				"message": "Invalid experiment",
+				"details": err.Error(),
+			})
+		}
+		if errors.Is(err, service.ErrOverlappingExperiment) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"code":    fiber.StatusConflict,
+				"message": "Experiment overlaps a running experiment",
+				"details": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code":    fiber.StatusInternalServerError,
+			"message": "Failed to create experiment",
+			"details": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(experiment)
+}
+
+// GetAll returns all experiments, running and stopped
+func (h *ExperimentHandler) GetAll(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.experiments.GetAll())
+}
+
+// GetByID returns a single experiment
+func (h *ExperimentHandler) GetByID(c *fiber.Ctx) error {
+	experiment, err := h.experiments.GetByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"code":    fiber.StatusNotFound,
+			"message": "Experiment not found",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(experiment)
+}
+
+// Stop ends an experiment, its traffic goes back to the default scoring
+func (h *ExperimentHandler) Stop(c *fiber.Ctx) error {
+	experiment, err := h.experiments.Stop(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"code":    fiber.StatusNotFound,
+			"message": "Experiment not found",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(experiment)
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"strings"
+
 	"sweng-task/internal/model"
 
 	"sweng-task/internal/service"
@@ -34,6 +36,7 @@ func (h *LineItemHandler) Create(c *fiber.Ctx) error {
 		})
 	}
 
+	normalizeGeo(input.Geo)
 	// Note: Validation logic should be implemented by the candidate
 	if err := validate.Struct(input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -54,6 +57,20 @@ func (h *LineItemHandler) Create(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(lineItem)
 }
 
+// normalizeGeo upper-cases the country and region codes, the ISO validators are case-sensitive and requests are
+// matched upper-cased
+func normalizeGeo(geo *model.GeoTargeting) {
+	if geo == nil {
+		return
+	}
+	for i, country := range geo.Countries {
+		geo.Countries[i] = strings.ToUpper(strings.TrimSpace(country))
+	}
+	for i, region := range geo.Regions {
+		geo.Regions[i] = strings.ToUpper(strings.TrimSpace(region))
+	}
+}
+
 // GetByID handles retrieving a line item by ID
 func (h *LineItemHandler) GetByID(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+)
+
+func TestLineItemCreateGeo(t *testing.T) {
+	tests := []struct {
+		name          string
+		geo           string
+		wantStatus    int
+		wantCountries []string
+		wantRegions   []string
+	}{
+		{name: "upper case", geo: `{"countries":["US"],"regions":["US-CA"]}`, wantStatus: fiber.StatusCreated, wantCountries: []string{"US"}, wantRegions: []string{"US-CA"}},
+		{name: "lower case", geo: `{"countries":["us","de"],"regions":["us-ca"]}`, wantStatus: fiber.StatusCreated, wantCountries: []string{"US", "DE"}, wantRegions: []string{"US-CA"}},
+		{name: "padded", geo: `{"countries":[" fr "]}`, wantStatus: fiber.StatusCreated, wantCountries: []string{"FR"}},
+		{name: "unknown country", geo: `{"countries":["xx"]}`, wantStatus: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := zap.NewNop().Sugar()
+			app := fiber.New()
+			app.Post("/api/v1/lineitems", NewLineItemHandler(service.NewLineItemService(log), log).Create)
+
+			body := `{"name":"Geo","advertiser_id":"adv-1","bid":1,"budget":1000,"placement":"homepage_top","geo":` + tt.geo + `}`
+			req := httptest.NewRequest(fiber.MethodPost, "/api/v1/lineitems", strings.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != fiber.StatusCreated {
+				return
+			}
+			var item model.LineItem
+			if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(item.Geo.Countries, ",") != strings.Join(tt.wantCountries, ",") || strings.Join(item.Geo.Regions, ",") != strings.Join(tt.wantRegions, ",") {
+				t.Errorf("geo %+v, want countries %v regions %v", item.Geo, tt.wantCountries, tt.wantRegions)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"strconv"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// OpenRTBHandler lets SSPs and Prebid Server buy from the line items over OpenRTB 2.6
+type OpenRTBHandler struct {
+	logs   *zap.SugaredLogger
+	ad     *service.AdService
+	signer *service.TokenSigner
+}
+
+// NewOpenRTBHandler creates a new OpenRTBHandler
+func NewOpenRTBHandler(log *zap.SugaredLogger, adv *service.AdService, signer *service.TokenSigner) *OpenRTBHandler {
+	return &OpenRTBHandler{
+		logs:   log,
+		ad:     adv,
+		signer: signer,
+	}
+}
+
+// Bid answers an OpenRTB BidRequest with a BidResponse, or 204 when nothing bids
+func (h *OpenRTBHandler) Bid(c *fiber.Ctx) error {
+	c.Set("X-Openrtb-Version", "2.6")
+	var request model.BidRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid bid request",
+			"details": err.Error(),
+		})
+	}
+	if err := validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid bid request",
+			"details": err.Error(),
+		})
+	}
+
+	response := h.ad.BidOpenRTB(request)
+	if response == nil {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// WinNotice is called by the exchange (nurl) when a bid wins its auction, the token of the nurl says which bid won
+func (h *OpenRTBHandler) WinNotice(c *fiber.Ctx) error {
+	token, err := h.signer.Verify(c.Query("t"))
+	if err == nil && token.EventType != model.TokenTypeWinNotice {
+		err = service.ErrInvalidToken
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid win notice",
+			"details": err.Error(),
+		})
+	}
+	// an exchange not replacing the macro sends "${AUCTION_PRICE}", the win still counts
+	price, _ := strconv.ParseFloat(c.Query("price"), 64)
+	h.ad.RecordWinNotice(token.AuctionID, token.LineItemID, token.Placement, price)
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+)
+
+func TestOpenRTBWinNotice(t *testing.T) {
+	cfg := testConfig(t)
+	log := zap.NewNop().Sugar()
+	lis := service.NewLineItemService(log)
+	item, err := lis.Create(model.LineItemCreate{
+		Name:         "Test",
+		AdvertiserID: "adv-1",
+		Bid:          2,
+		Budget:       1000,
+		Placement:    "homepage_top",
+		Keywords:     []string{"shoes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTimeDB, normalizer := service.NewRunTimeDB(log), service.NewNormalizer(log)
+	service.NewDataProcessorService(log, runTimeDB, lis, normalizer).PopulateCache()
+	signer := service.NewTokenSigner(log, cfg)
+	ad := service.NewAdService(log, cfg, runTimeDB, lis, normalizer, service.NewCTREstimator(log, cfg),
+		service.NewExperimentService(log), signer, service.NewAuctionStore(log, cfg))
+	handler := NewOpenRTBHandler(log, ad, signer)
+	app := fiber.New()
+	app.Post("/openrtb2/bid", handler.Bid)
+	app.Get("/openrtb2/win", handler.WinNotice)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/openrtb2/bid", strings.NewReader(
+		`{"id":"req-1","imp":[{"id":"1","tagid":"homepage_top","banner":{"w":300,"h":250}}],"site":{"keywords":"shoes"}}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("bid: status %d, want %d", resp.StatusCode, fiber.StatusOK)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	var bidResponse model.BidResponse
+	if err := json.Unmarshal(data, &bidResponse); err != nil {
+		t.Fatal(err)
+	}
+	if len(bidResponse.SeatBid) != 1 || len(bidResponse.SeatBid[0].Bid) != 1 {
+		t.Fatalf("bid response %s, want one bid", data)
+	}
+	nurl, err := url.Parse(strings.Replace(bidResponse.SeatBid[0].Bid[0].NURL, "${AUCTION_PRICE}", "1.5", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, param := range []string{"line_item_id", "bid_id", "placement"} {
+		if nurl.Query().Has(param) {
+			t.Errorf("nurl has the unsigned %s: %s", param, nurl)
+		}
+	}
+	signed := nurl.Query().Get("t")
+	token, err := signer.Verify(signed)
+	if err != nil {
+		t.Fatalf("nurl token: %v", err)
+	}
+	if token.LineItemID != item.ID || token.AuctionID != bidResponse.SeatBid[0].Bid[0].ID || token.EventType != model.TokenTypeWinNotice {
+		t.Errorf("nurl token %+v, want line item %s and bid %s", token, item.ID, bidResponse.SeatBid[0].Bid[0].ID)
+	}
+
+	expiredCfg := testConfig(t)
+	expiredCfg.Tracking.TokenTTL = -time.Minute
+	expired := service.NewTokenSigner(log, expiredCfg).Sign(*token)
+	click := signer.Sign(model.TrackingToken{AuctionID: token.AuctionID, LineItemID: item.ID, Placement: "homepage_top", Price: 2})
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{name: "nurl", target: nurl.RequestURI(), wantStatus: fiber.StatusOK},
+		{name: "price macro not replaced", target: "/openrtb2/win?t=" + url.QueryEscape(signed) + "&price=${AUCTION_PRICE}", wantStatus: fiber.StatusOK},
+		{name: "no token", target: "/openrtb2/win?line_item_id=" + item.ID + "&price=1.5", wantStatus: fiber.StatusBadRequest},
+		{name: "tampered token", target: "/openrtb2/win?t=" + url.QueryEscape(strings.Replace(signed, ".", "x.", 1)), wantStatus: fiber.StatusBadRequest},
+		{name: "expired token", target: "/openrtb2/win?t=" + url.QueryEscape(expired), wantStatus: fiber.StatusBadRequest},
+		{name: "click token", target: "/openrtb2/win?t=" + url.QueryEscape(click), wantStatus: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, tt.target, nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
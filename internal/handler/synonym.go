@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"net/url"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// SynonymHandler lets operators manage the keyword synonym dictionary
+type SynonymHandler struct {
+	logs       *zap.SugaredLogger
+	normalizer *service.Normalizer
+}
+
+// NewSynonymHandler creates a new SynonymHandler
+func NewSynonymHandler(log *zap.SugaredLogger, normalizer *service.Normalizer) *SynonymHandler {
+	return &SynonymHandler{
+		logs:       log,
+		normalizer: normalizer,
+	}
+}
+
+// GetAll returns the whole synonym dictionary
+func (h *SynonymHandler) GetAll(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.normalizer.GetSynonyms())
+}
+
+// Set replaces the synonyms of a single keyword
+func (h *SynonymHandler) Set(c *fiber.Ctx) error {
+	term, err := url.PathUnescape(c.Params("term"))
+	if err != nil || term == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Missing keyword",
+		})
+	}
+
+	var input model.SynonymUpdate
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	if err := validate.Struct(input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	synonyms := h.normalizer.SetSynonyms(term, input.Synonyms)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"term":     h.normalizer.Normalize(term),
+		"synonyms": synonyms,
+	})
+}
+
+// Delete removes a keyword from the synonym dictionary
+func (h *SynonymHandler) Delete(c *fiber.Ctx) error {
+	term, err := url.PathUnescape(c.Params("term"))
+	if err != nil || term == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Missing keyword",
+		})
+	}
+
+	if !h.normalizer.RemoveSynonyms(term) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"code":    fiber.StatusNotFound,
+			"message": "Keyword has no synonyms",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
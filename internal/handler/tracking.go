@@ -1,9 +1,15 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"net/url"
+	"strings"
+	"sweng-task/internal/config"
 	"sweng-task/internal/model"
 	"sweng-task/internal/service"
 	"time"
@@ -11,13 +17,33 @@ import (
 
 type TrackingHandler struct {
 	logs   *zap.SugaredLogger
-	pubSub *service.PubSub
+	cfg    *config.Config
+	pubSub service.EventPublisher
+	lis    *service.LineItemService
+	ctr    *service.CTREstimator
+	signer *service.TokenSigner
+	// served auctions, the auction_id of unsigned events is checked against it
+	auctions *service.AuctionStore
+	dedup    *service.Deduplicator
 }
 
-func NewTrackingHandler(log *zap.SugaredLogger, sub *service.PubSub) *TrackingHandler {
+// transparent 1x1 gif returned by the impression pixel
+var pixelGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+func NewTrackingHandler(log *zap.SugaredLogger, cfg *config.Config, sub service.EventPublisher, lis *service.LineItemService, ctr *service.CTREstimator, signer *service.TokenSigner, auctions *service.AuctionStore, dedup *service.Deduplicator) *TrackingHandler {
 	return &TrackingHandler{
-		logs:   log,
-		pubSub: sub,
+		logs:     log,
+		cfg:      cfg,
+		pubSub:   sub,
+		lis:      lis,
+		ctr:      ctr,
+		signer:   signer,
+		auctions: auctions,
+		dedup:    dedup,
 	}
 }
 
@@ -41,20 +67,384 @@ func (t *TrackingHandler) TrackEvent(c *fiber.Ctx) error {
 		})
 	}
 
-	stats := map[string]int{}
-	if query.EventType == "impression" {
-		stats["impression"]++
-	} else if query.EventType == "click" {
-		stats["click"]++
-	} else if query.EventType == "conversion" {
-		stats["conversion"]++
+	price, verified, err := t.check(&query)
+	if err != nil {
+		t.logs.Warnw("Tracking event rejected", "auction_id", query.AuctionID, "line_item_id", query.LineItemID, "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid tracking event",
+			"details": err.Error(),
+		})
+	}
+	if !t.pubSub.Accepting() {
+		return t.unavailable(c, service.ErrQueueFull)
 	}
 
-	msg := fmt.Sprintf(`{"event_time": %q,"event_minute": %d,"item_id": "%s","user_id": "%s","placement": "%s","keyword": "%s","clicks": %d,"impressions": %d,"conversions": %d}`, time.Now().Format(time.RFC3339Nano), time.Now().Minute(), query.LineItemID, query.UserID,
-		query.Placement, "keyword", stats["impression"], stats["click"], stats["conversion"])
-
-	// conversion to string possible from query model.TrackingEvent to json, but then i have to sarama have change it to byte
-	// that's same thing, that's why msg was built like this.
-	t.pubSub.Publish(msg)
+	if err := t.track(query, price, verified, traceID(c)); err != nil {
+		return t.unavailable(c, err)
+	}
 	return c.JSON(fiber.StatusAccepted)
 }
+
+// TrackURL records events fired as plain GET requests, VAST players and browsers can't POST a body.
+// Everything comes from the query string, metadata is the user agent of the player
+func (t *TrackingHandler) TrackURL(c *fiber.Ctx) error {
+	var query model.TrackingEvent
+	if err := c.QueryParser(&query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Failed to parse query parameters",
+			"details": err.Error(),
+		})
+	}
+	query.Metadata = map[string]string{"user_agent": c.Get(fiber.HeaderUserAgent)}
+	if err := validate.Struct(query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid query parameters",
+			"details": err.Error(),
+		})
+	}
+
+	price, verified, err := t.check(&query)
+	if err != nil {
+		t.logs.Warnw("Tracking event rejected", "auction_id", query.AuctionID, "line_item_id", query.LineItemID, "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid tracking event",
+			"details": err.Error(),
+		})
+	}
+	if !t.pubSub.Accepting() {
+		return t.unavailable(c, service.ErrQueueFull)
+	}
+
+	if err := t.track(query, price, verified, traceID(c)); err != nil {
+		return t.unavailable(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ImpressionPixel records the impression of the signed token and always answers with the gif, a broken pixel
+// must not break the page. Invalid or expired tokens are not recorded
+func (t *TrackingHandler) ImpressionPixel(c *fiber.Ctx) error {
+	if token, err := t.verifyToken(c, model.TrackingEventTypeImpression); err != nil {
+		t.logs.Warnw("Impression pixel rejected", "error", err)
+	} else {
+		if err := t.track(t.tokenEvent(c, token, model.TrackingEventTypeImpression), token.Price, true, traceID(c)); err != nil {
+			t.logs.Warnw("Impression pixel not recorded", "error", err)
+		}
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store, no-cache, must-revalidate")
+	c.Set(fiber.HeaderContentType, "image/gif")
+	return c.Status(fiber.StatusOK).Send(pixelGIF)
+}
+
+// ClickRedirect records the click of the signed token and sends the user to the landing page of the line item
+func (t *TrackingHandler) ClickRedirect(c *fiber.Ctx) error {
+	token, err := t.verifyToken(c, model.TrackingEventTypeClick)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid click token",
+			"details": err.Error(),
+		})
+	}
+	landingPage, err := t.lis.LandingPage(token.LineItemID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"code":    fiber.StatusNotFound,
+			"message": "Line item not found",
+		})
+	}
+	// the user gets to the landing page even when the click can't be recorded
+	if err := t.track(t.tokenEvent(c, token, model.TrackingEventTypeClick), token.Price, true, traceID(c)); err != nil {
+		t.logs.Warnw("Click not recorded", "error", err)
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Redirect(t.conversionURL(landingPage, token), fiber.StatusFound)
+}
+
+// conversionURL adds the signed conversion token of the click to the landing page, the advertiser sends it back with
+// the conversion (conversion_token) and the conversion is attributed to the ad long after the auction was forgotten
+func (t *TrackingHandler) conversionURL(landingPage string, token *model.TrackingToken) string {
+	target, err := url.Parse(landingPage)
+	if err != nil {
+		return landingPage
+	}
+	query := target.Query()
+	query.Set("conversion_token", t.signer.SignConversion(*token))
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// TrackSignedEvent records the event of a signed VAST tracking URL (video progress, click tracking, error), the event
+// type is in the token. Error URLs carry the VAST error code the player filled into the [ERRORCODE] macro
+func (t *TrackingHandler) TrackSignedEvent(c *fiber.Ctx) error {
+	token, err := t.signer.Verify(c.Query("t"))
+	// conversion tokens are posted with the conversion, they don't go through here
+	if err == nil && (token.EventType == "" || token.EventType == model.TrackingEventTypeConversion) {
+		err = service.ErrInvalidToken
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid tracking token",
+			"details": err.Error(),
+		})
+	}
+	event := t.tokenEvent(c, token, token.EventType)
+	if token.EventType == model.TrackingEventTypeError {
+		event.ErrorCode = c.Query("error_code")
+	}
+	if err := validate.Struct(event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid query parameters",
+			"details": err.Error(),
+		})
+	}
+	if !t.pubSub.Accepting() {
+		return t.unavailable(c, service.ErrQueueFull)
+	}
+
+	if err := t.track(event, token.Price, true, traceID(c)); err != nil {
+		return t.unavailable(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TrackBatch records the events buffered by an SDK, sent as a JSON array or as NDJSON (one event per line). Every event
+// is validated on its own, the response has the outcome of each one in request order. Valid events are published in one producer batch
+func (t *TrackingHandler) TrackBatch(c *fiber.Ctx) error {
+	events, err := splitBatch(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	if len(events) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code":    fiber.StatusBadRequest,
+			"message": "Empty batch",
+		})
+	}
+	if len(events) > t.cfg.Tracking.MaxBatchSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"code":    fiber.StatusRequestEntityTooLarge,
+			"message": "Too many events",
+			"details": fmt.Sprintf("a batch can have at most %d events, got %d", t.cfg.Tracking.MaxBatchSize, len(events)),
+		})
+	}
+
+	if !t.pubSub.Accepting() {
+		return t.unavailable(c, service.ErrQueueFull)
+	}
+
+	// one request, one trace id for all of its events
+	trace := traceID(c)
+	results := make([]model.TrackingEventResult, len(events))
+	messages := make([]service.Message, 0, len(events))
+	// events of the messages, recorded once they are published
+	pending := make([]batchEvent, 0, len(events))
+	for i, data := range events {
+		results[i] = model.TrackingEventResult{Index: i, Status: model.TrackingStatusRejected}
+		var event model.TrackingEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			results[i].Error = "invalid JSON: " + err.Error()
+			continue
+		}
+		if err := validate.Struct(event); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		price, verified, err := t.check(&event)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		event.EventID = service.EventID(event)
+		results[i].EventID = event.EventID
+		// reserved until it's published, a second copy in the batch is a duplicate too
+		if !t.dedup.Reserve(event) {
+			results[i].Status = model.TrackingStatusDuplicate
+			continue
+		}
+		results[i].Status = model.TrackingStatusAccepted
+		messages = append(messages, t.message(event, price, trace))
+		pending = append(pending, batchEvent{index: i, event: event, price: price, verified: verified})
+	}
+
+	for i, err := range t.pubSub.PublishBatch(messages) {
+		if err != nil {
+			// not counted, resubmitting it is not a duplicate
+			t.dedup.Release(pending[i].event)
+			results[pending[i].index].Status = model.TrackingStatusFailed
+			results[pending[i].index].Error = err.Error()
+			continue
+		}
+		t.dedup.Commit(pending[i].event)
+		t.record(pending[i].event, pending[i].price, pending[i].verified)
+	}
+	accepted := 0
+	for _, result := range results {
+		if result.Status == model.TrackingStatusAccepted {
+			accepted++
+		}
+	}
+	t.logs.Debugw("Tracking batch recorded", "events", len(events), "accepted", accepted)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"accepted": accepted, "results": results})
+}
+
+// batchEvent is an event of a batch waiting for its message to be published, index is its result
+type batchEvent struct {
+	index    int
+	event    model.TrackingEvent
+	price    float64
+	verified bool
+}
+
+// splitBatch splits a JSON array or NDJSON body into the raw events, blank NDJSON lines are skipped
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		var events []json.RawMessage
+		if err := json.Unmarshal(body, &events); err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+	var events []json.RawMessage
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			events = append(events, json.RawMessage(line))
+		}
+	}
+	return events, nil
+}
+
+// check verifies the event and returns its clearing price. verified events carry the auction_id of a served auction,
+// or the conversion token of the click for conversions: the auction is long gone then, the token takes the auction_id
+// and placement of the click. Conversions without token need an existing line item and can't be attributed to a forgotten auction
+func (t *TrackingHandler) check(event *model.TrackingEvent) (float64, bool, error) {
+	if event.EventType == model.TrackingEventTypeConversion {
+		if _, err := t.lis.GetByID(event.LineItemID); err != nil {
+			return 0, false, err
+		}
+		if event.ConversionToken != "" {
+			token, err := t.signer.Verify(event.ConversionToken)
+			if err != nil {
+				return 0, false, err
+			}
+			if token.EventType != model.TrackingEventTypeConversion || token.LineItemID != event.LineItemID ||
+				(event.AuctionID != "" && event.AuctionID != token.AuctionID) {
+				return 0, false, service.ErrInvalidToken
+			}
+			event.AuctionID, event.Placement = token.AuctionID, token.Placement
+			return 0, true, nil
+		}
+	}
+	price, err := t.auctions.Check(*event)
+	if err != nil {
+		return 0, false, err
+	}
+	return price, event.AuctionID != "", nil
+}
+
+// verifyToken checks the signed token of the request is one for the event type, pixel and click tokens have no event type
+func (t *TrackingHandler) verifyToken(c *fiber.Ctx, eventType model.TrackingEventType) (*model.TrackingToken, error) {
+	token, err := t.signer.Verify(c.Query("t"))
+	if err != nil {
+		return nil, err
+	}
+	if token.EventType != "" && token.EventType != eventType {
+		return nil, service.ErrInvalidToken
+	}
+	return token, nil
+}
+
+// tokenEvent builds the tracking event from the signed token, the price of the token is passed to track separately
+func (t *TrackingHandler) tokenEvent(c *fiber.Ctx, token *model.TrackingToken, eventType model.TrackingEventType) model.TrackingEvent {
+	event := model.TrackingEvent{
+		EventType:    eventType,
+		LineItemID:   token.LineItemID,
+		AuctionID:    token.AuctionID,
+		Timestamp:    time.Now(),
+		Placement:    token.Placement,
+		UserID:       token.UserID,
+		ExperimentID: token.ExperimentID,
+		VariantID:    token.VariantID,
+		Metadata:     map[string]string{"user_agent": c.Get(fiber.HeaderUserAgent)},
+	}
+	return event
+}
+
+// track publishes the event and then records it, retries (same event id) are dropped before they are published.
+// The event id is reserved while the event is published, a concurrent retry is a duplicate as well.
+// price is the clearing price of the served ad (signed token or auction store), 0 when the event isn't tied to an auction.
+// verified events came with a signed token or the auction_id of a served auction, only they feed the CTR
+func (t *TrackingHandler) track(query model.TrackingEvent, price float64, verified bool, traceID string) error {
+	query.EventID = service.EventID(query)
+	if !t.dedup.Reserve(query) {
+		return nil
+	}
+	if err := t.pubSub.Publish(t.message(query, price, traceID)); err != nil {
+		// nothing is counted, the retry of the client is not a duplicate
+		t.dedup.Release(query)
+		return err
+	}
+	t.dedup.Commit(query)
+	t.record(query, price, verified)
+	return nil
+}
+
+// unavailable tells the client to retry later, the tracking queue is full
+func (t *TrackingHandler) unavailable(c *fiber.Ctx, err error) error {
+	c.Set(fiber.HeaderRetryAfter, "1")
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"code":    fiber.StatusServiceUnavailable,
+		"message": "Tracking is overloaded, retry later",
+		"details": err.Error(),
+	})
+}
+
+// traceID is the trace id of the W3C traceparent header, else the X-Request-ID of the client, else a new one
+func traceID(c *fiber.Ctx) string {
+	// version-traceid-parentid-flags
+	if parts := strings.Split(c.Get("traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		return parts[1]
+	}
+	if requestID := c.Get(fiber.HeaderXRequestID); requestID != "" && len(requestID) <= 128 {
+		return requestID
+	}
+	return uuid.NewString()
+}
+
+// message builds the kafka message of the event
+func (t *TrackingHandler) message(query model.TrackingEvent, price float64, traceID string) service.Message {
+	message := service.NewTrackingMessage(query, price, t.auctions.Context(query.AuctionID, query.LineItemID))
+	message.TraceID = traceID
+	return service.EncodeTrackingMessage(message, t.cfg)
+}
+
+// record counts the published event (CTR, spend)
+func (t *TrackingHandler) record(query model.TrackingEvent, price float64, verified bool) {
+	// feeds the eCPM ranking, unverified clicks can be posted by anyone for any line item
+	if verified {
+		t.ctr.Record(query)
+	}
+
+	if query.EventType == model.TrackingEventTypeImpression && query.AuctionID != "" {
+		t.auctions.MarkImpression(query.AuctionID, query.LineItemID)
+	}
+
+	// Clearing price is only paid for impressions, it's CPM so a single impression costs price/1000.
+	// The price the client sends is never booked, unverified events have no price
+	if query.EventType == model.TrackingEventTypeImpression && price > 0 {
+		if err := t.lis.AddSpend(query.LineItemID, price/1000); err != nil {
+			t.logs.Warnw("Failed to book spend", "line_item_id", query.LineItemID, "error", err)
+		}
+	}
+}
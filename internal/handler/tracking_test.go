@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+)
+
+// slowPublisher holds every publish a little, concurrent retries overlap while the first copy is published
+type slowPublisher struct {
+	*service.MemoryPublisher
+	delay time.Duration
+}
+
+func (p *slowPublisher) Publish(msg service.Message) error {
+	time.Sleep(p.delay)
+	return p.MemoryPublisher.Publish(msg)
+}
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	cfg.Tracking.Secret = "test-secret"
+	return cfg
+}
+
+// trackingTest serves the tracking routes of main.go with the publisher
+type trackingTest struct {
+	app    *fiber.App
+	lis    *service.LineItemService
+	signer *service.TokenSigner
+}
+
+func newTrackingTest(t *testing.T, cfg *config.Config, publisher service.EventPublisher) *trackingTest {
+	t.Helper()
+	log := zap.NewNop().Sugar()
+	tt := &trackingTest{
+		lis:    service.NewLineItemService(log),
+		signer: service.NewTokenSigner(log, cfg),
+	}
+	handler := NewTrackingHandler(log, cfg, publisher, tt.lis, service.NewCTREstimator(log, cfg), tt.signer,
+		service.NewAuctionStore(log, cfg), service.NewDeduplicator(log, cfg))
+	tt.app = fiber.New()
+	tt.app.Post("/api/v1/tracking", handler.TrackEvent)
+	tt.app.Get("/t/imp.gif", handler.ImpressionPixel)
+	tt.app.Get("/t/click", handler.ClickRedirect)
+	return tt
+}
+
+// lineItem creates a line item landing on landingPage
+func (tt *trackingTest) lineItem(t *testing.T, landingPage string) *model.LineItem {
+	t.Helper()
+	item, err := tt.lis.Create(model.LineItemCreate{
+		Name:         "Test",
+		AdvertiserID: "adv-1",
+		Bid:          2,
+		Budget:       1000,
+		Placement:    "homepage_top",
+		Creative:     &model.Creative{ClickThroughURL: landingPage},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+// get returns the status and Location header of the response
+func (tt *trackingTest) get(t *testing.T, target string) (int, string) {
+	t.Helper()
+	resp, err := tt.app.Test(httptest.NewRequest(fiber.MethodGet, target, nil), -1)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderLocation)
+}
+
+func (tt *trackingTest) post(t *testing.T, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodPost, "/api/v1/tracking", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := tt.app.Test(req, -1)
+	if err != nil {
+		// Errorf, it's called from other goroutines too
+		t.Errorf("POST /api/v1/tracking: %v", err)
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+const testImpression = `{"event_id":"evt-1","event_type":"impression","line_item_id":"li-1","placement":"homepage_top","user_id":"user-1","metadata":{}}`
+
+func TestTrackEventConcurrentRetries(t *testing.T) {
+	cfg := testConfig(t)
+	publisher := &slowPublisher{MemoryPublisher: service.NewMemoryPublisher(zap.NewNop().Sugar()), delay: 20 * time.Millisecond}
+	tt := newTrackingTest(t, cfg, publisher)
+
+	const retries = 20
+	statuses := make([]int, retries)
+	var wg sync.WaitGroup
+	for i := range retries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], _ = tt.post(t, testImpression)
+		}()
+	}
+	wg.Wait()
+
+	for i, status := range statuses {
+		// retries are acknowledged like the first copy
+		if status >= 300 {
+			t.Errorf("retry %d: status %d, want success", i, status)
+		}
+	}
+	if got := len(publisher.Messages()); got != 1 {
+		t.Errorf("published %d messages, want 1", got)
+	}
+}
+
+func TestTrackConversion(t *testing.T) {
+	cfg := testConfig(t)
+	publisher := service.NewMemoryPublisher(zap.NewNop().Sugar())
+	tt := newTrackingTest(t, cfg, publisher)
+	item := tt.lineItem(t, "https://advertiser.example/landing?utm_source=ads")
+	other := tt.lineItem(t, "")
+	auctionID := uuid.NewString()
+
+	// the click hands the landing page the conversion token
+	click := tt.signer.Sign(model.TrackingToken{AuctionID: auctionID, LineItemID: item.ID, Placement: "homepage_top", Price: 2})
+	status, location := tt.get(t, "/t/click?t="+url.QueryEscape(click))
+	if status != fiber.StatusFound {
+		t.Fatalf("click: status %d, want %d", status, fiber.StatusFound)
+	}
+	landing, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if landing.Query().Get("utm_source") != "ads" {
+		t.Errorf("landing page lost its query: %s", location)
+	}
+	conversionToken := landing.Query().Get("conversion_token")
+	if conversionToken == "" {
+		t.Fatalf("landing page has no conversion_token: %s", location)
+	}
+
+	tests := []struct {
+		name       string
+		lineItemID string
+		auctionID  string
+		token      string
+		wantStatus int
+		// auction the published conversion is attributed to
+		wantAuctionID string
+	}{
+		{name: "conversion token", lineItemID: item.ID, token: conversionToken, wantStatus: fiber.StatusOK, wantAuctionID: auctionID},
+		{name: "conversion token and its auction", lineItemID: item.ID, auctionID: auctionID, token: conversionToken, wantStatus: fiber.StatusOK, wantAuctionID: auctionID},
+		{name: "tampered token", lineItemID: item.ID, token: conversionToken + "x", wantStatus: fiber.StatusBadRequest},
+		{name: "token of another line item", lineItemID: other.ID, token: conversionToken, wantStatus: fiber.StatusBadRequest},
+		{name: "token of another auction", lineItemID: item.ID, auctionID: uuid.NewString(), token: conversionToken, wantStatus: fiber.StatusBadRequest},
+		{name: "click token", lineItemID: item.ID, token: click, wantStatus: fiber.StatusBadRequest},
+		{name: "made up auction", lineItemID: item.ID, auctionID: uuid.NewString(), wantStatus: fiber.StatusBadRequest},
+		{name: "unknown line item", lineItemID: "li-unknown", wantStatus: fiber.StatusBadRequest},
+		{name: "unattributed", lineItemID: item.ID, wantStatus: fiber.StatusOK},
+	}
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			publisher.Reset()
+			status, body := tt.post(t, fmt.Sprintf(`{"event_id":"conversion-%d","event_type":"conversion","line_item_id":%q,"auction_id":%q,"conversion_token":%q,"placement":"checkout","user_id":"user-1","metadata":{}}`,
+				i, tc.lineItemID, tc.auctionID, tc.token))
+			if status != tc.wantStatus {
+				t.Fatalf("status %d, want %d: %s", status, tc.wantStatus, body)
+			}
+			messages := publisher.Messages()
+			if tc.wantStatus != fiber.StatusOK {
+				if len(messages) != 0 {
+					t.Error("rejected conversion published")
+				}
+				return
+			}
+			if len(messages) != 1 {
+				t.Fatalf("published %d messages, want 1", len(messages))
+			}
+			if want := fmt.Sprintf(`"auction_id":%q`, tc.wantAuctionID); !strings.Contains(string(messages[0].Value), want) {
+				t.Errorf("message %s, want %s", messages[0].Value, want)
+			}
+		})
+	}
+}
+
+// pausedPublisher is a MemoryPublisher whose queue is full
+type pausedPublisher struct {
+	*service.MemoryPublisher
+}
+
+func (p *pausedPublisher) Accepting() bool {
+	return false
+}
+
+func TestTrackingHandler(t *testing.T) {
+	cfg := testConfig(t)
+	signer := service.NewTokenSigner(zap.NewNop().Sugar(), cfg)
+	pixel := signer.Sign(model.TrackingToken{AuctionID: uuid.NewString(), LineItemID: "li-1", Placement: "homepage_top", Price: 2})
+	tampered := strings.Replace(pixel, ".", "x.", 1)
+
+	type request struct {
+		method string
+		target string
+		body   string
+	}
+	post := request{method: fiber.MethodPost, target: "/api/v1/tracking", body: testImpression}
+	tests := []struct {
+		name      string
+		accepting bool
+		// sent in order, the response of the last one is checked
+		requests       []request
+		wantStatus     int
+		wantRetryAfter string
+		wantMessages   int
+	}{
+		{name: "accept", accepting: true, requests: []request{post}, wantStatus: fiber.StatusOK, wantMessages: 1},
+		{name: "duplicate", accepting: true, requests: []request{post, post, post}, wantStatus: fiber.StatusOK, wantMessages: 1},
+		{name: "invalid event", accepting: true, requests: []request{{method: fiber.MethodPost, target: "/api/v1/tracking", body: `{"event_type":"impression"}`}}, wantStatus: fiber.StatusBadRequest},
+		{name: "signed pixel", accepting: true, requests: []request{{method: fiber.MethodGet, target: "/t/imp.gif?t=" + url.QueryEscape(pixel)}}, wantStatus: fiber.StatusOK, wantMessages: 1},
+		{name: "pixel with a bad signature", accepting: true, requests: []request{{method: fiber.MethodGet, target: "/t/imp.gif?t=" + url.QueryEscape(tampered)}}, wantStatus: fiber.StatusOK},
+		{name: "click with a bad signature", accepting: true, requests: []request{{method: fiber.MethodGet, target: "/t/click?t=" + url.QueryEscape(tampered)}}, wantStatus: fiber.StatusBadRequest},
+		{name: "queue full", accepting: false, requests: []request{post}, wantStatus: fiber.StatusServiceUnavailable, wantRetryAfter: "1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			memory := service.NewMemoryPublisher(zap.NewNop().Sugar())
+			var publisher service.EventPublisher = memory
+			if !tc.accepting {
+				publisher = &pausedPublisher{MemoryPublisher: memory}
+			}
+			tt := newTrackingTest(t, cfg, publisher)
+
+			var resp *http.Response
+			for _, r := range tc.requests {
+				req := httptest.NewRequest(r.method, r.target, strings.NewReader(r.body))
+				req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+				var err error
+				if resp, err = tt.app.Test(req, -1); err != nil {
+					t.Fatalf("%s %s: %v", r.method, r.target, err)
+				}
+				resp.Body.Close()
+			}
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if got := resp.Header.Get(fiber.HeaderRetryAfter); got != tc.wantRetryAfter {
+				t.Errorf("Retry-After %q, want %q", got, tc.wantRetryAfter)
+			}
+			if got := len(memory.Messages()); got != tc.wantMessages {
+				t.Errorf("published %d messages, want %d", got, tc.wantMessages)
+			}
+		})
+	}
+}
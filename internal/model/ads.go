@@ -1,20 +1,47 @@
 package model
 
-// Ad represents an advertisement ready to be served
+// Ad represents an advertisement ready to be served. Explored is set when the slot was given by the exploration policy
+// instead of the ranking, ExperimentID and VariantID have to be sent back with the tracking events.
+// ImpressionURL (1x1 gif) and ClickURL (redirect to the landing page) are signed and record the event themselves.
+// AuctionID is shared by all ads of one response, tracking events send it back as auction_id
 type Ad struct {
-	ID           string  `json:"id"`
-	Name         string  `json:"name"`
-	AdvertiserID string  `json:"advertiser_id"`
-	Bid          float64 `json:"bid"`
-	Placement    string  `json:"placement"`
-	ServeURL     string  `json:"serve_url"`
-	Relevance    int     `json:"relevance"`
+	ID            string  `json:"id"`
+	AuctionID     string  `json:"auction_id"`
+	Name          string  `json:"name"`
+	AdvertiserID  string  `json:"advertiser_id"`
+	Bid           float64 `json:"bid"`
+	Price         float64 `json:"price"`
+	Placement     string  `json:"placement"`
+	ServeURL      string  `json:"serve_url"`
+	Relevance     int     `json:"relevance"`
+	Explored      bool    `json:"explored,omitempty"`
+	ExperimentID  string  `json:"experiment_id,omitempty"`
+	VariantID     string  `json:"variant_id,omitempty"`
+	ImpressionURL string  `json:"impression_url"`
+	ClickURL      string  `json:"click_url"`
 }
 
-// WinningAdsQuery represents Winning ad request from router and specifies its requirement
+// WinningAdsQuery represents Winning ad request from router and specifies its requirement.
+// MaxPerAdvertiser defaults to APP_AUCTION_MAX_ADS_PER_ADVERTISER, Seed rotates exactly tied ads (same seed, same order),
+// UserID buckets the request into the running experiment and Device is parsed from the User-Agent header, never from the query string.
+// Keywords and Categories are extra page context filled by the batch and OpenRTB endpoints, they match like Keyword and Category.
+// BidFloor is the floor of the OpenRTB imp, it applies on top of the configured floors, Video only lets line items with a video creative in
 type WinningAdsQuery struct {
-	Placement string `query:"placement" validate:"required,max=50"`
-	Keyword   string `query:"keyword" validate:"omitempty,max=50"`
-	Category  string `query:"category" validate:"omitempty,max=50"`
-	Limit     int    `query:"limit" validate:"omitempty,min=1"`
+	Placement        string   `query:"placement" validate:"required,max=50"`
+	Keyword          string   `query:"keyword" validate:"omitempty,max=50"`
+	Category         string   `query:"category" validate:"omitempty,max=50"`
+	Limit            int      `query:"limit" validate:"omitempty,min=1"`
+	MaxPerAdvertiser int      `query:"max_per_advertiser" validate:"omitempty,min=1"`
+	Seed             int64    `query:"seed"`
+	UserID           string   `query:"user_id" validate:"omitempty,max=100"`
+	Debug            bool     `query:"debug"`
+	Country          string   `query:"country" validate:"omitempty,iso3166_1_alpha2"`
+	Region           string   `query:"region" validate:"omitempty,iso3166_2"`
+	Lat              *float64 `query:"lat" validate:"required_with=Lon,omitempty,latitude"`
+	Lon              *float64 `query:"lon" validate:"required_with=Lat,omitempty,longitude"`
+	Keywords         []string `query:"-"`
+	Categories       []string `query:"-"`
+	BidFloor         float64  `query:"-"`
+	Video            bool     `query:"-"`
+	Device           Device   `query:"-"`
 }
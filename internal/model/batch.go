@@ -0,0 +1,36 @@
+package model
+
+// BatchAdsRequest asks for the ads of every slot of a page in one call. Slots are auctioned in the given order and
+// an ad placed on one slot is not repeated on the others, so the most valuable slot should come first.
+// MaxPerAdvertiser caps the ads of one advertiser on the whole page (defaults to APP_AUCTION_MAX_ADS_PER_ADVERTISER)
+type BatchAdsRequest struct {
+	Context          PageContext `json:"context"`
+	Slots            []AdSlot    `json:"slots" validate:"required,min=1,max=20,unique=ID,dive"`
+	MaxPerAdvertiser int         `json:"max_per_advertiser" validate:"omitempty,min=1"`
+}
+
+// PageContext is the targeting shared by all slots of the page
+type PageContext struct {
+	Keywords   []string `json:"keywords" validate:"omitempty,max=20,dive,max=50"`
+	Categories []string `json:"categories" validate:"omitempty,max=20,dive,max=50"`
+	UserID     string   `json:"user_id" validate:"omitempty,max=100"`
+	Seed       int64    `json:"seed"`
+	Country    string   `json:"country" validate:"omitempty,iso3166_1_alpha2"`
+	Region     string   `json:"region" validate:"omitempty,iso3166_2"`
+	Lat        *float64 `json:"lat" validate:"required_with=Lon,omitempty,latitude"`
+	Lon        *float64 `json:"lon" validate:"required_with=Lat,omitempty,longitude"`
+}
+
+// AdSlot is a single ad position of the page, ID is chosen by the caller and echoed back in the response
+type AdSlot struct {
+	ID        string `json:"id" validate:"required,max=50"`
+	Placement string `json:"placement" validate:"required,max=50"`
+	Limit     int    `json:"limit" validate:"omitempty,min=1,max=10"`
+}
+
+// SlotAds are the winning ads of a slot, empty when nothing could fill it
+type SlotAds struct {
+	SlotID    string `json:"slot_id"`
+	Placement string `json:"placement"`
+	Ads       []*Ad  `json:"ads"`
+}
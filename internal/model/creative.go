@@ -0,0 +1,20 @@
+package model
+
+// Creative is what a line item shows. Video line items need MediaFiles (served as VAST InLine) or a third party
+// VASTTagURL (served as VAST Wrapper). Duration is in seconds, ClickThroughURL is the advertiser landing page
+type Creative struct {
+	MediaFiles      []MediaFile `json:"media_files,omitempty" validate:"required_without=VASTTagURL,omitempty,max=10,dive"`
+	Duration        int         `json:"duration,omitempty" validate:"required_with=MediaFiles,omitempty,min=1,max=600"`
+	ClickThroughURL string      `json:"click_through_url,omitempty" validate:"omitempty,url,max=2000"`
+	VASTTagURL      string      `json:"vast_tag_url,omitempty" validate:"omitempty,url,max=2000"`
+}
+
+// MediaFile is a single rendition of a video creative
+type MediaFile struct {
+	URL      string `json:"url" validate:"required,url,max=2000"`
+	MimeType string `json:"mime_type" validate:"required,max=50"`
+	Width    int    `json:"width" validate:"required,min=1,max=7680"`
+	Height   int    `json:"height" validate:"required,min=1,max=4320"`
+	Bitrate  int    `json:"bitrate,omitempty" validate:"omitempty,min=1"`
+	Delivery string `json:"delivery,omitempty" validate:"omitempty,oneof=progressive streaming"`
+}
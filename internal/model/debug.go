@@ -0,0 +1,39 @@
+package model
+
+// Candidate outcomes in the auction debug output
+const (
+	CandidateWon      = "won"
+	CandidateLost     = "lost"
+	CandidateExcluded = "excluded"
+)
+
+// AuctionDebug explains a single ad selection, answers "why didn't my ad show?"
+type AuctionDebug struct {
+	Placement      string            `json:"placement"`
+	Keywords       []string          `json:"keywords"`
+	Categories     []string          `json:"categories"`
+	AuctionType    string            `json:"auction_type"`
+	RankingMode    string            `json:"ranking_mode"`
+	ExperimentID   string            `json:"experiment_id,omitempty"`
+	VariantID      string            `json:"variant_id,omitempty"`
+	Floor          float64           `json:"floor"`
+	DroppedByFloor int               `json:"dropped_by_floor"`
+	Candidates     []*CandidateDebug `json:"candidates"`
+}
+
+// CandidateDebug is a line item of the requested placement and what happened to it. Breakdown is keyed by the
+// scoring weight names (keywordWeight, categoryWeight, bidWeight, paramWeight, ...)
+// plus ecpm in ecpm ranking mode, where it replaces the score. Bucket is -1 when it never got ranked
+type CandidateDebug struct {
+	LineItemID   string             `json:"line_item_id"`
+	Name         string             `json:"name"`
+	AdvertiserID string             `json:"advertiser_id"`
+	Bid          float64            `json:"bid"`
+	Score        float64            `json:"score"`
+	Breakdown    map[string]float64 `json:"breakdown"`
+	Bucket       int                `json:"bucket"`
+	Rank         int                `json:"rank,omitempty"`
+	Price        float64            `json:"price,omitempty"`
+	Outcome      string             `json:"outcome"`
+	Reason       string             `json:"reason,omitempty"`
+}
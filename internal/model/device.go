@@ -0,0 +1,34 @@
+package model
+
+// Device is what we know about the user's device, parsed from the User-Agent header
+type Device struct {
+	Type    string `json:"type,omitempty"`
+	OS      string `json:"os,omitempty"`
+	Browser string `json:"browser,omitempty"`
+}
+
+const (
+	DeviceTypeDesktop = "desktop"
+	DeviceTypeMobile  = "mobile"
+	DeviceTypeTablet  = "tablet"
+	DeviceTypeCTV     = "ctv"
+	DeviceTypeBot     = "bot"
+
+	DeviceOSIOS      = "ios"
+	DeviceOSAndroid  = "android"
+	DeviceOSWindows  = "windows"
+	DeviceOSMacOS    = "macos"
+	DeviceOSChromeOS = "chromeos"
+	DeviceOSLinux    = "linux"
+
+	BrowserChrome  = "chrome"
+	BrowserSafari  = "safari"
+	BrowserFirefox = "firefox"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+	BrowserIE      = "ie"
+
+	// DeviceOther is used for an OS or browser we don't recognise
+	DeviceOther = "other"
+)
@@ -0,0 +1,47 @@
+package model
+
+import "time"
+
+// ExperimentStatus represents the status of an experiment
+type ExperimentStatus string
+
+const (
+	ExperimentStatusRunning ExperimentStatus = "running"
+	ExperimentStatusStopped ExperimentStatus = "stopped"
+)
+
+// Experiment splits the ad traffic of a placement (all placements when empty) between variants by user_id
+type Experiment struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Placement string           `json:"placement,omitempty"`
+	Variants  []Variant        `json:"variants"`
+	Status    ExperimentStatus `json:"status"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// Variant is one arm of an experiment. Weight is its relative share of the traffic, Scoring overrides single
+// CoreScoring weights (the rest stay as they are) and RankingMode overrides the ranking mode of the placement.
+// A variant without overrides is the control
+type Variant struct {
+	ID          string             `json:"id" validate:"required,max=50"`
+	Weight      int                `json:"weight" validate:"required,min=1,max=10000"`
+	Scoring     map[string]float64 `json:"scoring,omitempty" validate:"omitempty,dive,gte=0"`
+	RankingMode string             `json:"ranking_mode,omitempty" validate:"omitempty,oneof=relevance ecpm"`
+}
+
+// ExperimentCreate represents the data needed to create a new experiment
+type ExperimentCreate struct {
+	Name      string    `json:"name" validate:"required,max=100"`
+	Placement string    `json:"placement" validate:"omitempty,max=50"`
+	Variants  []Variant `json:"variants" validate:"required,min=2,max=10,dive"`
+}
+
+// Assignment is the variant a single request was bucketed into
+type Assignment struct {
+	ExperimentID string
+	VariantID    string
+	Scoring      map[string]float64
+	RankingMode  string
+}
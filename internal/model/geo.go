@@ -0,0 +1,15 @@
+package model
+
+// GeoTargeting limits a line item to locations, a request matches when it hits any of the countries, regions or areas
+type GeoTargeting struct {
+	Countries []string  `json:"countries,omitempty" validate:"omitempty,dive,iso3166_1_alpha2"`
+	Regions   []string  `json:"regions,omitempty" validate:"omitempty,dive,iso3166_2"`
+	Areas     []GeoArea `json:"areas,omitempty" validate:"omitempty,dive"`
+}
+
+// GeoArea is a point-radius area, e.g. 25km around a store
+type GeoArea struct {
+	Lat      float64 `json:"lat" validate:"latitude"`
+	Lon      float64 `json:"lon" validate:"longitude"`
+	RadiusKm float64 `json:"radius_km" validate:"gt=0,lte=500"`
+}
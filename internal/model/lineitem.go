@@ -15,26 +15,47 @@ const (
 
 // LineItem represents an advertisement with associated bid information
 type LineItem struct {
-	ID           string         `json:"id"`
-	Name         string         `json:"name"`
-	AdvertiserID string         `json:"advertiser_id"`
-	Bid          float64        `json:"bid"`
-	Budget       float64        `json:"budget"`
-	Placement    string         `json:"placement"`
-	Categories   []string       `json:"categories,omitempty"`
-	Keywords     []string       `json:"keywords,omitempty"`
-	Status       LineItemStatus `json:"status"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
+	ID                    string         `json:"id"`
+	Name                  string         `json:"name"`
+	AdvertiserID          string         `json:"advertiser_id"`
+	Bid                   float64        `json:"bid"`
+	Budget                float64        `json:"budget"`
+	Spend                 float64        `json:"spend"`
+	Placement             string         `json:"placement"`
+	Categories            []string       `json:"categories,omitempty"`
+	Keywords              []string       `json:"keywords,omitempty"`
+	ExcludedKeywords      []string       `json:"excluded_keywords,omitempty"`
+	ExcludedCategories    []string       `json:"excluded_categories,omitempty"`
+	PrefixMatch           bool           `json:"prefix_match,omitempty"`
+	FuzzyMatch            bool           `json:"fuzzy_match,omitempty"`
+	Geo                   *GeoTargeting  `json:"geo,omitempty"`
+	DeviceTypes           []string       `json:"device_types,omitempty"`
+	OperatingSystems      []string       `json:"operating_systems,omitempty"`
+	Browsers              []string       `json:"browsers,omitempty"`
+	CompetitiveCategories []string       `json:"competitive_categories,omitempty"`
+	Creative              *Creative      `json:"creative,omitempty"`
+	Status                LineItemStatus `json:"status"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
 }
 
 // LineItemCreate represents the data needed to create a new line item
 type LineItemCreate struct {
-	Name         string   `json:"name" validate:"required,min=1,max=100"`
-	AdvertiserID string   `json:"advertiser_id" validate:"required"`
-	Bid          float64  `json:"bid" validate:"required,gte=0.1,lte=10"`
-	Budget       float64  `json:"budget" validate:"required,gte=1000,lte=10000"`
-	Placement    string   `json:"placement" validate:"required,oneof=homepage_sidebar video_preroll article_inline_1 mobile_sticky footer_banner homepage_top article_inline_2"`
-	Categories   []string `json:"categories,omitempty"`
-	Keywords     []string `json:"keywords,omitempty"`
+	Name                  string        `json:"name" validate:"required,min=1,max=100"`
+	AdvertiserID          string        `json:"advertiser_id" validate:"required"`
+	Bid                   float64       `json:"bid" validate:"required,gte=0.1,lte=10"`
+	Budget                float64       `json:"budget" validate:"required,gte=1000,lte=10000"`
+	Placement             string        `json:"placement" validate:"required,oneof=homepage_sidebar video_preroll article_inline_1 mobile_sticky footer_banner homepage_top article_inline_2"`
+	Categories            []string      `json:"categories,omitempty"`
+	Keywords              []string      `json:"keywords,omitempty"`
+	ExcludedKeywords      []string      `json:"excluded_keywords,omitempty" validate:"omitempty,dive,required,max=50"`
+	ExcludedCategories    []string      `json:"excluded_categories,omitempty" validate:"omitempty,dive,required,max=50"`
+	PrefixMatch           bool          `json:"prefix_match,omitempty"`
+	FuzzyMatch            bool          `json:"fuzzy_match,omitempty"`
+	Geo                   *GeoTargeting `json:"geo,omitempty" validate:"omitempty"`
+	DeviceTypes           []string      `json:"device_types,omitempty" validate:"omitempty,dive,oneof=desktop mobile tablet ctv"`
+	OperatingSystems      []string      `json:"operating_systems,omitempty" validate:"omitempty,dive,oneof=ios android windows macos chromeos linux other"`
+	Browsers              []string      `json:"browsers,omitempty" validate:"omitempty,dive,oneof=chrome safari firefox edge opera samsung ie other"`
+	CompetitiveCategories []string      `json:"competitive_categories,omitempty" validate:"omitempty,dive,required,max=50"`
+	Creative              *Creative     `json:"creative,omitempty" validate:"omitempty"`
 }
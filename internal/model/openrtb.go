@@ -0,0 +1,107 @@
+package model
+
+// OpenRTB 2.6 objects, only the fields the bidder reads or writes. Unknown fields of the exchange are ignored
+
+// BidRequest is the top level OpenRTB request, one auction of the exchange with one or more impressions
+type BidRequest struct {
+	ID     string         `json:"id" validate:"required,max=100"`
+	Imp    []Imp          `json:"imp" validate:"required,min=1,max=20,dive"`
+	Site   *Site          `json:"site,omitempty"`
+	Device *OpenRTBDevice `json:"device,omitempty"`
+	User   *User          `json:"user,omitempty"`
+	Cur    []string       `json:"cur,omitempty"`
+}
+
+// Imp is a single ad slot of the request, TagID is the placement
+type Imp struct {
+	ID          string  `json:"id" validate:"required,max=100"`
+	TagID       string  `json:"tagid" validate:"required,max=50"`
+	Banner      *Banner `json:"banner,omitempty"`
+	Video       *Video  `json:"video,omitempty"`
+	BidFloor    float64 `json:"bidfloor,omitempty" validate:"gte=0"`
+	BidFloorCur string  `json:"bidfloorcur,omitempty"`
+}
+
+// Banner describes a display slot, W/H or the first Format is used for the markup size
+type Banner struct {
+	W      int      `json:"w,omitempty"`
+	H      int      `json:"h,omitempty"`
+	Format []Format `json:"format,omitempty"`
+}
+
+// Format is an allowed banner size
+type Format struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// Video describes a video slot
+type Video struct {
+	Mimes       []string `json:"mimes,omitempty"`
+	W           int      `json:"w,omitempty"`
+	H           int      `json:"h,omitempty"`
+	MinDuration int      `json:"minduration,omitempty"`
+	MaxDuration int      `json:"maxduration,omitempty"`
+}
+
+// Site is the page the impressions are on, Keywords is comma separated, KwArray is its 2.6 array form
+type Site struct {
+	ID       string   `json:"id,omitempty"`
+	Domain   string   `json:"domain,omitempty"`
+	Page     string   `json:"page,omitempty"`
+	Cat      []string `json:"cat,omitempty" validate:"omitempty,max=20,dive,max=50"`
+	Keywords string   `json:"keywords,omitempty" validate:"omitempty,max=1000"`
+	KwArray  []string `json:"kwarray,omitempty" validate:"omitempty,max=20,dive,max=50"`
+}
+
+// OpenRTBDevice is the device of the user. Geo country is ISO 3166-1 alpha-3 in OpenRTB, region is ISO 3166-2
+// (the subdivision part only, e.g. "CA" for California)
+type OpenRTBDevice struct {
+	UA         string `json:"ua,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	Geo        *Geo   `json:"geo,omitempty"`
+	DeviceType int    `json:"devicetype,omitempty"`
+}
+
+// Geo is a location in OpenRTB
+type Geo struct {
+	Lat     *float64 `json:"lat,omitempty" validate:"required_with=Lon,omitempty,latitude"`
+	Lon     *float64 `json:"lon,omitempty" validate:"required_with=Lat,omitempty,longitude"`
+	Country string   `json:"country,omitempty"`
+	Region  string   `json:"region,omitempty"`
+}
+
+// User is the human behind the device, ID buckets the request into experiments like user_id does
+type User struct {
+	ID       string   `json:"id,omitempty" validate:"omitempty,max=100"`
+	Keywords string   `json:"keywords,omitempty" validate:"omitempty,max=1000"`
+	KwArray  []string `json:"kwarray,omitempty" validate:"omitempty,max=20,dive,max=50"`
+	Geo      *Geo     `json:"geo,omitempty"`
+}
+
+// BidResponse answers a BidRequest, a request without bids is answered with 204 instead
+type BidResponse struct {
+	ID      string    `json:"id"`
+	SeatBid []SeatBid `json:"seatbid"`
+	BidID   string    `json:"bidid,omitempty"`
+	Cur     string    `json:"cur"`
+}
+
+// SeatBid groups the bids of a seat, the bidder uses a single seat
+type SeatBid struct {
+	Bid []Bid `json:"bid"`
+}
+
+// Bid is the offer for one impression. Price is CPM, NURL is the win notice with the exchange macros
+type Bid struct {
+	ID      string   `json:"id"`
+	ImpID   string   `json:"impid"`
+	Price   float64  `json:"price"`
+	NURL    string   `json:"nurl,omitempty"`
+	AdM     string   `json:"adm,omitempty"`
+	ADomain []string `json:"adomain,omitempty"`
+	CID     string   `json:"cid,omitempty"`
+	CrID    string   `json:"crid"`
+	W       int      `json:"w,omitempty"`
+	H       int      `json:"h,omitempty"`
+}
@@ -0,0 +1,6 @@
+package model
+
+// SynonymUpdate represents the synonyms an operator wants to attach to a keyword
+type SynonymUpdate struct {
+	Synonyms []string `json:"synonyms" validate:"required,min=1,dive,required,max=50"`
+}
@@ -0,0 +1,19 @@
+package model
+
+// TrackingToken is the signed payload of the impression pixel and click redirect URLs. Everything the tracking event
+// needs is inside, so the client can't pick the line item or the price. ExpiresAt is unix seconds.
+// EventType is set on the tokens of the VAST event URLs (GET /t/event) and on conversion tokens, pixel and click tokens go without
+type TrackingToken struct {
+	AuctionID    string            `json:"a"`
+	LineItemID   string            `json:"l"`
+	Placement    string            `json:"p"`
+	Price        float64           `json:"c,omitempty"`
+	UserID       string            `json:"u,omitempty"`
+	ExperimentID string            `json:"e,omitempty"`
+	VariantID    string            `json:"v,omitempty"`
+	EventType    TrackingEventType `json:"t,omitempty"`
+	ExpiresAt    int64             `json:"x"`
+}
+
+// TokenTypeWinNotice is the EventType of the OpenRTB win notice tokens, no tracking URL takes them
+const TokenTypeWinNotice TrackingEventType = "win"
@@ -9,14 +9,50 @@ const (
 	TrackingEventTypeImpression TrackingEventType = "impression"
 	TrackingEventTypeClick      TrackingEventType = "click"
 	TrackingEventTypeConversion TrackingEventType = "conversion"
+	// Video events, fired by the VAST player
+	TrackingEventTypeStart         TrackingEventType = "start"
+	TrackingEventTypeFirstQuartile TrackingEventType = "first_quartile"
+	TrackingEventTypeMidpoint      TrackingEventType = "midpoint"
+	TrackingEventTypeThirdQuartile TrackingEventType = "third_quartile"
+	TrackingEventTypeComplete      TrackingEventType = "complete"
+	TrackingEventTypeSkip          TrackingEventType = "skip"
+	TrackingEventTypeError         TrackingEventType = "error"
 )
 
-// TrackingEvent represents a user interaction with an ad
+// TrackingEvent represents a user interaction with an ad, AuctionID is the auction_id of the ad response that served it.
+// EventID identifies the event across retries, a retry with the same id is acknowledged but not recorded again.
+// Price is what the client reports, spend always comes from the clearing price of the served auction.
+// ConversionToken is the token the click redirect added to the landing page URL, it attributes a conversion to the ad
 type TrackingEvent struct {
-	EventType  TrackingEventType `json:"event_type" query:"event_type" validate:"required,oneof=click conversion impression"`
-	LineItemID string            `json:"line_item_id" query:"line_item_id" validate:"required"`
-	Timestamp  time.Time         `json:"timestamp,omitempty" query:"timestamp" validate:"omitempty"`
-	Placement  string            `json:"placement,omitempty" query:"placement" validate:"required"`
-	UserID     string            `json:"user_id,omitempty" query:"user_id" validate:"required"`
-	Metadata   map[string]string `json:"metadata,omitempty" query:"metadata" validate:"required"`
+	EventID      string            `json:"event_id,omitempty" query:"event_id" validate:"omitempty,max=200"`
+	EventType    TrackingEventType `json:"event_type" query:"event_type" validate:"required,oneof=click conversion impression start first_quartile midpoint third_quartile complete skip error"`
+	LineItemID   string            `json:"line_item_id" query:"line_item_id" validate:"required"`
+	AuctionID    string            `json:"auction_id,omitempty" query:"auction_id" validate:"omitempty,uuid"`
+	Timestamp    time.Time         `json:"timestamp,omitempty" query:"timestamp" validate:"omitempty"`
+	Placement    string            `json:"placement,omitempty" query:"placement" validate:"required"`
+	UserID       string            `json:"user_id,omitempty" query:"user_id" validate:"required"`
+	Price        float64           `json:"price,omitempty" query:"price" validate:"omitempty,gte=0,lte=100"`
+	ExperimentID string            `json:"experiment_id,omitempty" query:"experiment_id" validate:"omitempty,max=50"`
+	VariantID    string            `json:"variant_id,omitempty" query:"variant_id" validate:"required_with=ExperimentID,omitempty,max=50"`
+	ErrorCode    string            `json:"error_code,omitempty" query:"error_code" validate:"omitempty,max=20"`
+	// conversion events only
+	ConversionToken string            `json:"conversion_token,omitempty" query:"conversion_token" validate:"omitempty,max=2000"`
+	Metadata        map[string]string `json:"metadata,omitempty" query:"metadata" validate:"required"`
+}
+
+// Outcome of a single event of a tracking batch
+const (
+	TrackingStatusAccepted  = "accepted"
+	TrackingStatusDuplicate = "duplicate"
+	TrackingStatusRejected  = "rejected"
+	TrackingStatusFailed    = "failed"
+)
+
+// TrackingEventResult is the outcome of the event at Index of a tracking batch, Error says why it was rejected or failed.
+// Failed events were valid but could not be published to kafka, they are not counted and can be sent again
+type TrackingEventResult struct {
+	Index   int    `json:"index"`
+	EventID string `json:"event_id,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
 }
@@ -0,0 +1,54 @@
+package model
+
+import "time"
+
+// TrackingSchemaVersion is the version of TrackingMessage, sent in the schema_version field and kafka header.
+// Version 1 was the untyped message built with fmt.Sprintf (clicks and impressions swapped, no metadata).
+// The protobuf encoding is described in api/tracking_event.proto
+const TrackingSchemaVersion = 2
+
+// TrackingMessage is what gets published for every tracking event. The field names of version 1 are kept
+// (item_id, keyword, clicks, ...) so the ClickHouse views keep working. EventTime is when the bidder took the event,
+// ClientTime the timestamp sent by the client if any. Keyword is the first request keyword of the auction.
+// Price is the clearing price of the served ad (0 when the event can't be tied to an auction), ReportedPrice the one the client sent.
+// Auction is only there while the auction is remembered (APP_TRACKING_AUCTION_TTL), conversions usually come later.
+// TraceID is not part of the message, it goes in the trace_id kafka header
+type TrackingMessage struct {
+	SchemaVersion int               `json:"schema_version"`
+	EventID       string            `json:"event_id"`
+	EventTime     time.Time         `json:"event_time"`
+	EventMinute   int               `json:"event_minute"`
+	EventType     TrackingEventType `json:"event_type"`
+	LineItemID    string            `json:"item_id"`
+	AuctionID     string            `json:"auction_id"`
+	UserID        string            `json:"user_id"`
+	Placement     string            `json:"placement"`
+	Keyword       string            `json:"keyword"`
+	Clicks        uint32            `json:"clicks"`
+	Impressions   uint32            `json:"impressions"`
+	Conversions   uint32            `json:"conversions"`
+	Price         float64           `json:"price"`
+	ReportedPrice float64           `json:"reported_price"`
+	ExperimentID  string            `json:"experiment_id"`
+	VariantID     string            `json:"variant_id"`
+	ErrorCode     string            `json:"error_code"`
+	ClientTime    *time.Time        `json:"client_time,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Auction       *AuctionContext   `json:"auction,omitempty"`
+	TraceID       string            `json:"-"`
+}
+
+// AuctionContext is what the auction knew when it served the ad. Position is the 1-based rank of the ad in the
+// response, Price its clearing price
+type AuctionContext struct {
+	ServedAt    time.Time `json:"served_at"`
+	Position    int       `json:"position"`
+	Price       float64   `json:"price"`
+	Keywords    []string  `json:"keywords,omitempty"`
+	Categories  []string  `json:"categories,omitempty"`
+	Country     string    `json:"country,omitempty"`
+	Region      string    `json:"region,omitempty"`
+	DeviceType  string    `json:"device_type,omitempty"`
+	RankingMode string    `json:"ranking_mode"`
+	Explored    bool      `json:"explored,omitempty"`
+}
@@ -0,0 +1,97 @@
+package model
+
+import "encoding/xml"
+
+// VAST 4.2 document, only the elements the ad server writes. A VAST without Ads is the "no ad" response
+
+type VAST struct {
+	XMLName xml.Name `xml:"VAST"`
+	Version string   `xml:"version,attr"`
+	Ads     []VASTAd `xml:"Ad"`
+}
+
+// VASTAd is one ad of the response, Sequence orders the ads of a pod
+type VASTAd struct {
+	ID       string       `xml:"id,attr"`
+	Sequence int          `xml:"sequence,attr,omitempty"`
+	InLine   *VASTInLine  `xml:"InLine,omitempty"`
+	Wrapper  *VASTWrapper `xml:"Wrapper,omitempty"`
+}
+
+// VASTInLine is an ad served with its own media files
+type VASTInLine struct {
+	AdSystem    string         `xml:"AdSystem"`
+	AdServingID string         `xml:"AdServingId"`
+	AdTitle     string         `xml:"AdTitle"`
+	Errors      []VASTURL      `xml:"Error"`
+	Impressions []VASTURL      `xml:"Impression"`
+	Pricing     *VASTPricing   `xml:"Pricing,omitempty"`
+	Creatives   []VASTCreative `xml:"Creatives>Creative"`
+}
+
+// VASTWrapper points the player to a third party VAST, our impression and tracking URLs still fire
+type VASTWrapper struct {
+	FollowAdditionalWrappers bool           `xml:"followAdditionalWrappers,attr"`
+	AllowMultipleAds         bool           `xml:"allowMultipleAds,attr"`
+	FallbackOnNoAd           bool           `xml:"fallbackOnNoAd,attr"`
+	AdSystem                 string         `xml:"AdSystem"`
+	Errors                   []VASTURL      `xml:"Error"`
+	Impressions              []VASTURL      `xml:"Impression"`
+	VASTAdTagURI             VASTURL        `xml:"VASTAdTagURI"`
+	Creatives                []VASTCreative `xml:"Creatives>Creative"`
+}
+
+// VASTURL is an URL element, written as CDATA so query strings don't need escaping
+type VASTURL struct {
+	ID  string `xml:"id,attr,omitempty"`
+	URL string `xml:",cdata"`
+}
+
+type VASTPricing struct {
+	Model    string `xml:"model,attr"`
+	Currency string `xml:"currency,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type VASTCreative struct {
+	ID            string             `xml:"id,attr,omitempty"`
+	UniversalAdID *VASTUniversalAdID `xml:"UniversalAdId,omitempty"`
+	Linear        VASTLinear         `xml:"Linear"`
+}
+
+type VASTUniversalAdID struct {
+	IDRegistry string `xml:"idRegistry,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// VASTLinear is the video itself, wrappers only carry TrackingEvents and VideoClicks
+type VASTLinear struct {
+	Duration       string           `xml:"Duration,omitempty"`
+	TrackingEvents []VASTTracking   `xml:"TrackingEvents>Tracking"`
+	VideoClicks    *VASTVideoClicks `xml:"VideoClicks,omitempty"`
+	MediaFiles     *VASTMediaFiles  `xml:"MediaFiles,omitempty"`
+}
+
+// VASTMediaFiles is a pointer in VASTLinear, wrappers must not write an empty MediaFiles element
+type VASTMediaFiles struct {
+	MediaFiles []VASTMediaFile `xml:"MediaFile"`
+}
+
+type VASTTracking struct {
+	Event string `xml:"event,attr"`
+	URL   string `xml:",cdata"`
+}
+
+type VASTVideoClicks struct {
+	ClickThrough   *VASTURL  `xml:"ClickThrough,omitempty"`
+	ClickTrackings []VASTURL `xml:"ClickTracking"`
+}
+
+type VASTMediaFile struct {
+	Delivery string `xml:"delivery,attr"`
+	Type     string `xml:"type,attr"`
+	Width    int    `xml:"width,attr"`
+	Height   int    `xml:"height,attr"`
+	Bitrate  int    `xml:"bitrate,attr,omitempty"`
+	URL      string `xml:",cdata"`
+}
@@ -2,11 +2,17 @@ package service
 
 import (
 	"fmt"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"math"
-	_ "slices"
+	"net/url"
+	"slices"
 	"sort"
+	"strings"
+	"sweng-task/internal/config"
 	"sweng-task/internal/model"
+	"time"
+	"unicode/utf8"
 )
 
 var CoreScoring map[string]float64 = map[string]float64{
@@ -14,6 +20,9 @@ var CoreScoring map[string]float64 = map[string]float64{
 	"categoryWeight": 5,
 	"bidWeight":      6,
 	"paramWeight":    5,
+	// partial matches always have to stay below an exact keyword match
+	"prefixKeywordWeight": 3,
+	"fuzzyKeywordWeight":  2,
 }
 
 // We can implement catagory specific scoring
@@ -23,123 +32,441 @@ var CategoryScoring map[string]float64 = map[string]float64{}
 var KeyWordsScoring map[string]float64 = map[string]float64{}
 
 type AdService struct {
-	logs      *zap.SugaredLogger
-	runTimeDB *RunTimeDB
-	lis       *LineItemService
+	logs        *zap.SugaredLogger
+	cfg         *config.Config
+	runTimeDB   *RunTimeDB
+	lis         *LineItemService
+	normalizer  *Normalizer
+	ctr         *CTREstimator
+	experiments *ExperimentService
+	signer      *TokenSigner
+	auctions    *AuctionStore
+	// category floors keyed by the normalized category, so they match the normalized request category
+	categoryFloors map[string]float64
 }
 
-func NewAdService(log *zap.SugaredLogger, runTimeDB *RunTimeDB, lis *LineItemService) *AdService {
+func NewAdService(log *zap.SugaredLogger, cfg *config.Config, runTimeDB *RunTimeDB, lis *LineItemService, normalizer *Normalizer, ctr *CTREstimator, experiments *ExperimentService, signer *TokenSigner, auctions *AuctionStore) *AdService {
+	categoryFloors := make(map[string]float64, len(cfg.Auction.CategoryFloors))
+	for category, floor := range cfg.Auction.CategoryFloors {
+		category = normalizer.Normalize(category)
+		categoryFloors[category] = math.Max(categoryFloors[category], floor)
+	}
 	return &AdService{
-		logs:      log,
-		runTimeDB: runTimeDB,
-		lis:       lis,
+		logs:           log,
+		cfg:            cfg,
+		runTimeDB:      runTimeDB,
+		lis:            lis,
+		normalizer:     normalizer,
+		ctr:            ctr,
+		experiments:    experiments,
+		signer:         signer,
+		auctions:       auctions,
+		categoryFloors: categoryFloors,
 	}
 }
 
+func (s *AdService) GetAd(query model.WinningAdsQuery) ([]*model.Ad, error) {
+	ads, _ := s.auction(query, nil, nil)
+	return ads, nil
+}
+
+// Explain runs the same ranking as GetAd without its side effects (see auctionTrace) and returns what happened to every
+// line item of the placement
+func (s *AdService) Explain(query model.WinningAdsQuery) ([]*model.Ad, *model.AuctionDebug, error) {
+	trace := newAuctionTrace()
+	ads, debug := s.auction(query, nil, trace)
+	debug.Candidates = trace.list()
+	return ads, debug, nil
+}
+
 // This whole thing optimises the FindMatchingLineItems and the ad selection part together, It's much more efficient
-func (s *AdService) GetAd(placement string, keyword string, category string, limit int) ([]*model.Ad, error) {
+// page is nil for a single placement request, batch requests share it between the slots of the page
+func (s *AdService) auction(query model.WinningAdsQuery, page *pageAuction, trace *auctionTrace) ([]*model.Ad, *model.AuctionDebug) {
+	placement, limit := query.Placement, query.Limit
+	if limit <= 0 {
+		limit = 1
+	}
+	// experiment variant (if the user is enrolled) decides the scoring weights and ranking mode of this request
+	assignment := s.experiments.Assign(placement, query.UserID)
+	weights := scoringWeights(assignment)
+	mode := s.rankingMode(placement, assignment)
+	debug := &model.AuctionDebug{Placement: placement, AuctionType: s.cfg.Auction.Type, RankingMode: mode}
+	if assignment != nil {
+		debug.ExperimentID, debug.VariantID = assignment.ExperimentID, assignment.VariantID
+	}
 	if len(s.runTimeDB.GetPlacements(placement)) == 0 {
-		return []*model.Ad{}, nil
+		return []*model.Ad{}, debug
 	}
 	relevanceSore := map[string]int{}
 	highestBid := -1.0
 	highestBidderId := "DummyString"
-	// Applying bucket sort, because as i need limited number of ads, so at scale (5K line items) we don't need to sort the whole array
-	// we just need to sort the highest value bucket until we hit desired amount of result. plus bucket sort is O(N) in insert and retrival
-	// does not need O(N) at all! We can tweak the minBin,maxBid and bucketGap later to increase the performance
-	minScore := 0.01
-	maxScore := 10.0
-	bucketGap := 0.50
-	bucketCount := int(math.Ceil((maxScore - minScore) / bucketGap))
-	buckets := make([][]*model.LineItem, bucketCount)
-
-	insertIntoBucket := func(item *model.LineItem, score float64) {
-		idx := int((score - minScore) / bucketGap)
-		if idx < 0 {
-			idx = 0
+	// Index holds normalized terms, so the query has to go through the same pipeline. keywords are expanded with their synonyms
+	keywords := s.expandKeywords(append([]string{query.Keyword}, query.Keywords...))
+	categories := s.normalizer.NormalizeAll(append([]string{query.Category}, query.Categories...))
+	// Brand safety, geo and device come first, blocked line items are hard filtered and never reach the scoring
+	excluded := s.runTimeDB.GetExclusions(keywords, categories)
+	geoMatched := s.runTimeDB.GetGeoMatches(query.Country, query.Region, query.Lat, query.Lon)
+	deviceTypes := s.runTimeDB.DeviceTypes.Allowed(query.Device.Type)
+	operatingSystems := s.runTimeDB.OperatingSystems.Allowed(query.Device.OS)
+	browsers := s.runTimeDB.Browsers.Allowed(query.Device.Browser)
+	// Bids under the floor can't win the slot at all, so they are dropped together with the targeting misses
+	floor := math.Max(s.floorPrice(placement, categories), query.BidFloor)
+	floorDropped := map[string]bool{}
+	blockReason := func(id string) string {
+		item := s.lis.items[id]
+		switch {
+		// keyword and category indexes are shared by all placements, line items of other placements can't compete here
+		case item.Placement != placement:
+			return reasonOtherPlacement
+		case excluded[id]:
+			return reasonExcluded
+		case page.hasServed(id):
+			return reasonAlreadyOnPage
+		case s.runTimeDB.GeoTargeted[id] && !geoMatched[id]:
+			return reasonGeo
+		case s.runTimeDB.DeviceTypes.Blocks(id, deviceTypes):
+			return reasonDeviceType
+		case s.runTimeDB.OperatingSystems.Blocks(id, operatingSystems):
+			return reasonOS
+		case s.runTimeDB.Browsers.Blocks(id, browsers):
+			return reasonBrowser
+		case query.Video && !hasVideoCreative(item):
+			return reasonNoCreative
+		case item.Bid < floor:
+			floorDropped[id] = true
+			return reasonBelowFloor
 		}
-		if idx >= bucketCount {
-			idx = bucketCount - 1
+		return ""
+	}
+	blocked := func(id string) bool {
+		reason := blockReason(id)
+		if reason != "" && reason != reasonOtherPlacement {
+			trace.exclude(s.lis.items[id], reason)
 		}
-		buckets[idx] = append(buckets[idx], item)
+		return reason != ""
 	}
-	// bucket sort prep ends
 	// There can be lineitems that does not have any targeting, created separate step for this use case
 	score := s.runTimeDB.GetInitialScoringWithTargetFreeItems()
 	paramMatch := map[string]int{}
 	// Initial scoring loop
 	for id := range score {
-		highestBid, highestBidderId = s.updateHighestBid(highestBid, highestBidderId, id)
-		insertIntoBucket(s.lis.items[id], score[id])
+		if blocked(id) {
+			delete(score, id)
+			continue
+		}
+		highestBid, highestBidderId = s.updateHighestBid(highestBid, highestBidderId, id, query.Seed)
 	}
 
 	// Keyword scoring loop
-	keywordIds := s.runTimeDB.GetKeyWords(keyword)
+	keywordIds := s.runTimeDB.GetKeyWordsAny(keywords)
+	exactKeyword := make(map[string]bool, len(keywordIds))
 	for _, id := range keywordIds {
-		score[id] += CoreScoring["keywordWeight"]
-		highestBid, highestBidderId = s.updateHighestBid(highestBid, highestBidderId, id)
+		if blocked(id) {
+			continue
+		}
+		exactKeyword[id] = true
+		score[id] += weights["keywordWeight"]
+		trace.addScore(s.lis.items[id], "keywordWeight", weights["keywordWeight"])
+		highestBid, highestBidderId = s.updateHighestBid(highestBid, highestBidderId, id, query.Seed)
 		// I need to check what percent of a particular line item is getting matched, so that i can send back in relvence
 		paramMatch[id]++
 		// if all params match that means the ad the 100% relevant
 		if paramMatch[id] == s.runTimeDB.ParameterCount[id] {
-			score[id] += CoreScoring["paramWeight"]
+			score[id] += weights["paramWeight"]
+			trace.addScore(s.lis.items[id], "paramWeight", weights["paramWeight"])
 		}
-		insertIntoBucket(s.lis.items[id], score[id])
 		relevanceSore[id] += 50
 	}
 
-	// Category scoring loop
-	categoryIds := s.runTimeDB.GetCategory(category)
-	for _, id := range categoryIds {
-		score[id] += CoreScoring["categoryWeight"]
-		highestBid, highestBidderId = s.updateHighestBid(highestBid, highestBidderId, id)
+	// Partial keyword scoring loop, only line items without an exact keyword match can get it, and always less than exact
+	for id, weight := range s.partialKeywordScores(keywords, placement, exactKeyword, weights) {
+		if blocked(id) {
+			continue
+		}
+		score[id] += weight
+		trace.addScore(s.lis.items[id], "partialKeywordWeight", weight)
+		highestBid, highestBidderId = s.updateHighestBid(highestBid, highestBidderId, id, query.Seed)
+		relevanceSore[id] += 25
+	}
+
+	// Category scoring loop, a line item matching several page categories still counts as one category match
+	for _, id := range s.runTimeDB.GetCategoriesAny(categories) {
+		if blocked(id) {
+			continue
+		}
+		score[id] += weights["categoryWeight"]
+		trace.addScore(s.lis.items[id], "categoryWeight", weights["categoryWeight"])
+		highestBid, highestBidderId = s.updateHighestBid(highestBid, highestBidderId, id, query.Seed)
 		paramMatch[id]++
 		// if all params match that means the ad the 100% relevant
 		if paramMatch[id] == s.runTimeDB.ParameterCount[id] {
-			score[id] += CoreScoring["paramWeight"]
+			score[id] += weights["paramWeight"]
+			trace.addScore(s.lis.items[id], "paramWeight", weights["paramWeight"])
 		}
-		insertIntoBucket(s.lis.items[id], score[id])
 		relevanceSore[id] += 50
 	}
 
-	if highestBidderId != "DummyString" {
+	if mode == RankingECPM {
+		// Relevance only decided who is a candidate, order comes from the expected revenue per thousand requests.
+		// bidWeight bonus is not added, the bid is already part of the eCPM
+		for id := range score {
+			item := s.lis.items[id]
+			ecpm := item.Bid * s.ctr.Predict(id, placement) * 1000
+			score[id] = ecpm
+			trace.addScore(item, "ecpm", ecpm)
+		}
+	} else if highestBidderId != "DummyString" {
 		// priority scoring done
-		score[highestBidderId] += CoreScoring["bidWeight"]
+		score[highestBidderId] += weights["bidWeight"]
+		trace.addScore(s.lis.items[highestBidderId], "bidWeight", weights["bidWeight"])
+	}
+
+	// Applying bucket sort, because as i need limited number of ads, so at scale (5K line items) we don't need to sort the whole array
+	// we just need to sort the highest value bucket until we hit desired amount of result. plus bucket sort is O(N) in insert and retrival
+	// does not need O(N) at all! We can tweak the minBin,maxBid and bucketGap later to increase the performance
+	// Range grows with the highest score, eCPM scores have no upper bound and would all end up in the top bucket otherwise
+	minScore := 0.01
+	maxScore := 10.0
+	for _, v := range score {
+		maxScore = math.Max(maxScore, v)
+	}
+	bucketGap := 0.50 * maxScore / 10
+	bucketCount := int(math.Ceil((maxScore - minScore) / bucketGap))
+	buckets := make([][]*model.LineItem, bucketCount)
+
+	insertIntoBucket := func(item *model.LineItem, score float64) {
+		idx := int((score - minScore) / bucketGap)
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= bucketCount {
+			idx = bucketCount - 1
+		}
+		buckets[idx] = append(buckets[idx], item)
+		trace.bucket(item, idx, score)
 	}
+	// bucket sort prep ends
+	// Every candidate goes into its bucket exactly once, after all the scoring is done. Inserting on every score change
+	// used to put the same line item into several buckets and serve it twice
+	for id := range score {
+		insertIntoBucket(s.lis.items[id], score[id])
+	}
+
 	// Even though it seems like This is Only worst case O(N^2), and that worst case is impossible to hit
-	result := []*model.Ad{}
-	for i := bucketCount - 1; i >= 0 && len(result) < limit; i-- {
+	// Every eligible line item is ranked after the winners, the auction prices the winners from the bids they beat.
+	// Line items breaking advertiser cap or competitive separation with the winners so far are skipped, next one moves up
+	maxPerAdvertiser := query.MaxPerAdvertiser
+	if maxPerAdvertiser == 0 {
+		maxPerAdvertiser = s.cfg.Auction.MaxAdsPerAdvertiser
+	}
+	separation := newSeparation(maxPerAdvertiser)
+	if page != nil {
+		// caps and competitive separation count the ads already placed on the other slots of the page
+		separation = page.separation.clone()
+	}
+	ranked := []*model.LineItem{}
+	for i := bucketCount - 1; i >= 0; i-- {
 		// This is running on very few number of items, that why this sort will is extremly efficient, also i think we can do a pre-sort
 		// type stuff during the insertion which will reduce sorting time more in big scale
-		sort.Slice(buckets[i], func(a, b int) bool {
-			idA := buckets[i][a].ID
-			idB := buckets[i][b].ID
-			return score[idA] > score[idB]
+		// Buckets are filled from map iteration, so the order inside has to come from a total order, not from luck
+		sort.SliceStable(buckets[i], func(a, b int) bool {
+			return rankBefore(buckets[i][a], buckets[i][b], score, query.Seed)
 		})
-		for _, ad := range buckets[i] {
-			result = append(result, &model.Ad{
-				ID:           ad.ID,
-				Name:         s.lis.items[ad.ID].Name,
-				AdvertiserID: s.lis.items[ad.ID].AdvertiserID,
-				Bid:          s.lis.items[ad.ID].Bid,
-				Placement:    s.lis.items[ad.ID].Placement,
-				ServeURL:     fmt.Sprintf("https://content.realtimemediatool.com/data/%s", ad.ID),
-				// Normally, there will be multiple keywords and you need to match with
-				//Relevance: (paramMatch[ad.ID] * 100) / s.runTimeDB.ParameterCount[ad.ID] - previous logic
-				Relevance: relevanceSore[ad.ID],
+		for _, item := range buckets[i] {
+			competitive := s.runTimeDB.CompetitiveCategories[item.ID]
+			if reason := separation.check(item.AdvertiserID, competitive); reason != "" {
+				trace.lose(item, reason)
+				continue
+			}
+			ranked = append(ranked, item)
+			trace.rank(item, len(ranked))
+			// losers only have to be eligible next to the winners, they don't block each other
+			if len(ranked) <= limit {
+				separation.add(item.AdvertiserID, competitive)
+			}
+		}
+	}
+
+	s.logs.Debugw("Auction floors applied",
+		"placement", placement,
+		"categories", categories,
+		"floor", floor,
+		"dropped_by_floor", len(floorDropped),
+	)
+
+	// every auction gets its own id, it ties the tracking events of the winners back to this response.
+	// A traced auction is only explained, it gets no id and can't be tracked
+	dryRun := trace != nil
+	auctionID := ""
+	if !dryRun {
+		auctionID = uuid.New().String()
+	}
+	winners := min(limit, len(ranked))
+	exploredID := ""
+	if !dryRun {
+		// the runner up stays out of the exploration pool, the rest of the losers can be explored
+		exploredID = s.explore(ranked[:min(winners+1, len(ranked))], winners, score, placement, maxPerAdvertiser, page, trace)
+	}
+	page.add(ranked[:winners], s.runTimeDB.CompetitiveCategories)
+	prices := s.clearingPrices(ranked, winners, floor)
+	result := make([]*model.Ad, 0, winners)
+	for i, ad := range ranked[:winners] {
+		trace.win(ad, prices[i])
+		if ad.ID == exploredID {
+			trace.explored(ad)
+		}
+		served := &model.Ad{
+			ID:           ad.ID,
+			AuctionID:    auctionID,
+			Name:         s.lis.items[ad.ID].Name,
+			AdvertiserID: s.lis.items[ad.ID].AdvertiserID,
+			Bid:          s.lis.items[ad.ID].Bid,
+			Price:        prices[i],
+			Placement:    s.lis.items[ad.ID].Placement,
+			ServeURL:     serveURL(ad.ID),
+			// Normally, there will be multiple keywords and you need to match with
+			//Relevance: (paramMatch[ad.ID] * 100) / s.runTimeDB.ParameterCount[ad.ID] - previous logic
+			Relevance: relevanceSore[ad.ID],
+			Explored:  ad.ID == exploredID,
+		}
+		if assignment != nil {
+			served.ExperimentID, served.VariantID = assignment.ExperimentID, assignment.VariantID
+		}
+		if !dryRun {
+			served.ImpressionURL, served.ClickURL = s.trackingPixelURLs(model.TrackingToken{
+				AuctionID:    auctionID,
+				LineItemID:   ad.ID,
+				Placement:    placement,
+				Price:        prices[i],
+				UserID:       query.UserID,
+				ExperimentID: served.ExperimentID,
+				VariantID:    served.VariantID,
 			})
-			if len(result) == limit {
-				return result, nil
+		}
+		result = append(result, served)
+	}
+	// explained auctions don't go in the store, ads_served_total only counts served ads
+	if !dryRun {
+		s.auctions.Record(auctionID, placement, result, model.AuctionContext{
+			ServedAt:    time.Now(),
+			Keywords:    s.normalizer.NormalizeAll(append([]string{query.Keyword}, query.Keywords...)),
+			Categories:  categories,
+			Country:     query.Country,
+			Region:      query.Region,
+			DeviceType:  query.Device.Type,
+			RankingMode: mode,
+		})
+	}
+
+	if trace != nil {
+		placementItems := []*model.LineItem{}
+		for _, id := range s.runTimeDB.GetPlacements(placement) {
+			placementItems = append(placementItems, s.lis.items[id])
+		}
+		trace.untouched(placementItems)
+	}
+	debug.Keywords = keywords
+	debug.Categories = categories
+	debug.Floor = floor
+	debug.DroppedByFloor = len(floorDropped)
+	return result, debug
+}
+
+// partialKeywordScores finds line items matching the keywords by prefix or edit distance. Each line item gets only its best
+// partial weight, fuzzy weight goes down with the distance. Modes are toggled per line item or for the whole placement
+func (s *AdService) partialKeywordScores(keywords []string, placement string, exact map[string]bool, weights map[string]float64) map[string]float64 {
+	matching := s.cfg.Matching
+	prefixPlacement := slices.Contains(matching.PrefixPlacements, placement)
+	fuzzyPlacement := slices.Contains(matching.FuzzyPlacements, placement)
+	partial := map[string]float64{}
+	addMatches := func(term string, weight float64, placementEnabled bool, itemEnabled map[string]bool) {
+		for _, id := range s.runTimeDB.GetKeyWords(term) {
+			if exact[id] || !(placementEnabled || itemEnabled[id]) {
+				continue
 			}
+			partial[id] = math.Max(partial[id], weight)
 		}
 	}
-	return result, nil
+
+	prefixEnabled := prefixPlacement || len(s.runTimeDB.PrefixMatch) > 0
+	fuzzyEnabled := fuzzyPlacement || len(s.runTimeDB.FuzzyMatch) > 0
+	for _, keyword := range keywords {
+		length := utf8.RuneCountInString(keyword)
+		if prefixEnabled && length >= matching.PrefixMinLength {
+			for _, term := range s.runTimeDB.GetKeyWordsByPrefix(keyword) {
+				addMatches(term, weights["prefixKeywordWeight"], prefixPlacement, s.runTimeDB.PrefixMatch)
+			}
+		}
+		if fuzzyEnabled && length >= matching.FuzzyMinLength {
+			for _, match := range s.runTimeDB.GetKeyWordsByDistance(keyword, matching.FuzzyMaxDistance) {
+				addMatches(match.Term, weights["fuzzyKeywordWeight"]/float64(match.Distance), fuzzyPlacement, s.runTimeDB.FuzzyMatch)
+			}
+		}
+	}
+	return partial
+}
+
+// trackingPixelURLs returns the signed impression pixel and click redirect URLs of a served ad
+func (s *AdService) trackingPixelURLs(token model.TrackingToken) (string, string) {
+	signed := url.QueryEscape(s.signer.Sign(token))
+	base := strings.TrimRight(s.cfg.Server.PublicURL, "/")
+	return base + "/t/imp.gif?t=" + signed, base + "/t/click?t=" + signed
+}
+
+func serveURL(lineItemID string) string {
+	return fmt.Sprintf("https://content.realtimemediatool.com/data/%s", lineItemID)
+}
+
+// expandKeywords normalizes and expands every keyword with its synonyms, without duplicates
+func (s *AdService) expandKeywords(terms []string) []string {
+	seen := map[string]bool{}
+	keywords := []string{}
+	for _, term := range terms {
+		for _, keyword := range s.normalizer.Expand(term) {
+			if !seen[keyword] {
+				seen[keyword] = true
+				keywords = append(keywords, keyword)
+			}
+		}
+	}
+	return keywords
 }
 
-func (s *AdService) updateHighestBid(currentHighest float64, currentBidder string, candidateID string) (float64, string) {
+// rankingMode returns how the placement is ranked, experiment variant first, then config, relevance by default
+func (s *AdService) rankingMode(placement string, assignment *model.Assignment) string {
+	if assignment != nil && assignment.RankingMode != "" {
+		return assignment.RankingMode
+	}
+	if mode, ok := s.cfg.Ranking.Modes[placement]; ok {
+		return mode
+	}
+	return RankingRelevance
+}
+
+// scoringWeights returns CoreScoring with the overrides of the experiment variant applied. CoreScoring itself is never
+// modified, it is shared by all requests
+func scoringWeights(assignment *model.Assignment) map[string]float64 {
+	if assignment == nil || len(assignment.Scoring) == 0 {
+		return CoreScoring
+	}
+	weights := make(map[string]float64, len(CoreScoring))
+	for name, weight := range CoreScoring {
+		weights[name] = weight
+	}
+	for name, weight := range assignment.Scoring {
+		weights[name] = weight
+	}
+	return weights
+}
+
+func (s *AdService) updateHighestBid(currentHighest float64, currentBidder string, candidateID string, seed int64) (float64, string) {
 	bid := s.lis.items[candidateID].Bid
 	if bid > currentHighest {
 		return bid, candidateID
 	}
+	// candidates come from map iteration, equal bids need a fixed winner or the bonus jumps around between calls
+	if bid == currentHighest && tieBefore(s.lis.items[candidateID], s.lis.items[currentBidder], seed) {
+		return bid, candidateID
+	}
 	return currentHighest, currentBidder
 }
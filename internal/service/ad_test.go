@@ -0,0 +1,122 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+)
+
+func testAdConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	cfg.Tracking.Secret = "test-secret"
+	return cfg
+}
+
+// newTestAdService indexes the line items like the server does at startup and returns them in the given order
+func newTestAdService(t *testing.T, cfg *config.Config, items ...model.LineItemCreate) (*AdService, []*model.LineItem) {
+	t.Helper()
+	log := zap.NewNop().Sugar()
+	lis := NewLineItemService(log)
+	created := make([]*model.LineItem, len(items))
+	for i, item := range items {
+		var err error
+		if created[i], err = lis.Create(item); err != nil {
+			t.Fatalf("Create %s: %v", item.Name, err)
+		}
+	}
+	runTimeDB, normalizer := NewRunTimeDB(log), NewNormalizer(log)
+	NewDataProcessorService(log, runTimeDB, lis, normalizer).PopulateCache()
+	s := NewAdService(log, cfg, runTimeDB, lis, normalizer, NewCTREstimator(log, cfg), NewExperimentService(log),
+		NewTokenSigner(log, cfg), NewAuctionStore(log, cfg))
+	return s, created
+}
+
+// testLineItem is a homepage_top line item matching the "shoes" keyword
+func testLineItem(name string, advertiserID string, bid float64) model.LineItemCreate {
+	return model.LineItemCreate{
+		Name:         name,
+		AdvertiserID: advertiserID,
+		Bid:          bid,
+		Budget:       1000,
+		Placement:    "homepage_top",
+		Keywords:     []string{"shoes"},
+	}
+}
+
+func competing(item model.LineItemCreate, categories ...string) model.LineItemCreate {
+	item.CompetitiveCategories = categories
+	return item
+}
+
+func adNames(ads []*model.Ad) []string {
+	names := make([]string, len(ads))
+	for i, ad := range ads {
+		names[i] = ad.Name
+	}
+	return names
+}
+
+func TestAuctionSeparation(t *testing.T) {
+	advertiserItems := []model.LineItemCreate{
+		testLineItem("a1", "adv-1", 5),
+		testLineItem("a2", "adv-1", 4),
+		testLineItem("a3", "adv-1", 3),
+		testLineItem("b1", "adv-2", 2),
+	}
+	tests := []struct {
+		name             string
+		items            []model.LineItemCreate
+		configuredCap    int
+		maxPerAdvertiser int
+		limit            int
+		want             []string
+	}{
+		{name: "no cap", items: advertiserItems, limit: 3, want: []string{"a1", "a2", "a3"}},
+		{name: "one per advertiser", items: advertiserItems, maxPerAdvertiser: 1, limit: 3, want: []string{"a1", "b1"}},
+		{name: "two per advertiser", items: advertiserItems, maxPerAdvertiser: 2, limit: 3, want: []string{"a1", "a2", "b1"}},
+		{name: "configured cap", items: advertiserItems, configuredCap: 1, limit: 3, want: []string{"a1", "b1"}},
+		{name: "request overrides configured cap", items: advertiserItems, configuredCap: 1, maxPerAdvertiser: 3, limit: 3, want: []string{"a1", "a2", "a3"}},
+		{
+			name: "competitive separation",
+			items: []model.LineItemCreate{
+				competing(testLineItem("airline-1", "adv-1", 5), "airline"),
+				competing(testLineItem("airline-2", "adv-2", 4), "Airlines"),
+				competing(testLineItem("hotel", "adv-3", 3), "hotel"),
+			},
+			limit: 3,
+			want:  []string{"airline-1", "hotel"},
+		},
+		{
+			name: "competitors only blocked by the winners",
+			items: []model.LineItemCreate{
+				testLineItem("a1", "adv-1", 5),
+				competing(testLineItem("a2", "adv-1", 4), "airline"),
+				competing(testLineItem("b1", "adv-2", 3), "airline"),
+			},
+			maxPerAdvertiser: 1,
+			limit:            2,
+			want:             []string{"a1", "b1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testAdConfig(t)
+			cfg.Auction.MaxAdsPerAdvertiser = tt.configuredCap
+			s, _ := newTestAdService(t, cfg, tt.items...)
+			ads, err := s.GetAd(model.WinningAdsQuery{Placement: "homepage_top", Keyword: "shoes", Limit: tt.limit, MaxPerAdvertiser: tt.maxPerAdvertiser})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := adNames(ads); !slices.Equal(got, tt.want) {
+				t.Errorf("ads %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
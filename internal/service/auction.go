@@ -0,0 +1,149 @@
+package service
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+
+	"sweng-task/internal/model"
+)
+
+// Auction types, selected with APP_AUCTION_TYPE
+const (
+	// AuctionFirstPrice every winner pays its own bid
+	AuctionFirstPrice = "first_price"
+	// AuctionSecondPrice every winner pays the highest losing bid (plus increment), for limit=1 it is the classic vickrey auction
+	AuctionSecondPrice = "second_price"
+	// AuctionGSP generalized second price, winner on rank i pays the highest bid ranked below it (plus increment)
+	AuctionGSP = "gsp"
+)
+
+// floorPrice is the lowest bid allowed to compete, highest of the placement floor and the category floor
+func (s *AdService) floorPrice(placement string, categories []string) float64 {
+	floor := s.cfg.Auction.PlacementFloors[placement]
+	for _, category := range categories {
+		floor = math.Max(floor, s.categoryFloors[category])
+	}
+	return floor
+}
+
+// clearingPrices prices the first `winners` line items of the ranked list, ranked has to contain every eligible loser
+// after the winners. Ranking is done on score, not on bid, so the highest losing bid can sit anywhere after the winners,
+// and the price is always capped by the winner's own bid and never goes under the reserve price (floor price, if it is higher).
+func (s *AdService) clearingPrices(ranked []*model.LineItem, winners int, floor float64) []float64 {
+	auction := s.cfg.Auction
+	reserve := math.Max(auction.ReservePrice, floor)
+	prices := make([]float64, winners)
+	for i := 0; i < winners; i++ {
+		bid := ranked[i].Bid
+		price := bid
+		switch auction.Type {
+		case AuctionSecondPrice:
+			price = s.beatingPrice(ranked[winners:], reserve)
+		case AuctionGSP:
+			price = s.beatingPrice(ranked[i+1:], reserve)
+		}
+		price = math.Max(price, reserve)
+		prices[i] = roundPrice(math.Min(price, bid))
+	}
+	return prices
+}
+
+// beatingPrice is what it costs to outbid all the given line items, reserve price when there are none
+func (s *AdService) beatingPrice(beaten []*model.LineItem, reserve float64) float64 {
+	if len(beaten) == 0 {
+		return reserve
+	}
+	highest := beaten[0].Bid
+	for _, item := range beaten[1:] {
+		highest = math.Max(highest, item.Bid)
+	}
+	return highest + s.cfg.Auction.PriceIncrement
+}
+
+func roundPrice(price float64) float64 {
+	return math.Round(price*10000) / 10000
+}
+
+// separation keeps the response diverse: at most maxPerAdvertiser ads of one advertiser (0 means no cap) and
+// never two ads sharing a competitive category (two airlines side by side)
+type separation struct {
+	maxPerAdvertiser int
+	perAdvertiser    map[string]int
+	competitive      map[string]bool
+}
+
+func newSeparation(maxPerAdvertiser int) *separation {
+	return &separation{
+		maxPerAdvertiser: maxPerAdvertiser,
+		perAdvertiser:    map[string]int{},
+		competitive:      map[string]bool{},
+	}
+}
+
+// check returns why the line item can't join the winners so far, empty string when it can
+func (s *separation) check(advertiserID string, competitiveCategories []string) string {
+	if s.maxPerAdvertiser > 0 && s.perAdvertiser[advertiserID] >= s.maxPerAdvertiser {
+		return reasonAdvertiserCap
+	}
+	for _, category := range competitiveCategories {
+		if s.competitive[category] {
+			return reasonCompetitive
+		}
+	}
+	return ""
+}
+
+func (s *separation) clone() *separation {
+	c := newSeparation(s.maxPerAdvertiser)
+	for advertiserID, count := range s.perAdvertiser {
+		c.perAdvertiser[advertiserID] = count
+	}
+	for category := range s.competitive {
+		c.competitive[category] = true
+	}
+	return c
+}
+
+func (s *separation) add(advertiserID string, competitiveCategories []string) {
+	s.perAdvertiser[advertiserID]++
+	for _, category := range competitiveCategories {
+		s.competitive[category] = true
+	}
+}
+
+// rankBefore is the total order of the auction: score, then bid, then tieBefore. Two calls with the same
+// candidates always rank them the same way
+func rankBefore(a, b *model.LineItem, score map[string]float64, seed int64) bool {
+	if score[a.ID] != score[b.ID] {
+		return score[a.ID] > score[b.ID]
+	}
+	if a.Bid != b.Bid {
+		return a.Bid > b.Bid
+	}
+	return tieBefore(a, b, seed)
+}
+
+// tieBefore breaks exact ties, oldest line item first then ID. With a seed the tie is rotated by a seeded hash instead,
+// so callers passing different seeds spread the ties fairly while the same seed still gives the same order
+func tieBefore(a, b *model.LineItem, seed int64) bool {
+	if seed != 0 {
+		hashA, hashB := seededHash(seed, a.ID), seededHash(seed, b.ID)
+		if hashA != hashB {
+			return hashA < hashB
+		}
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+func seededHash(seed int64, id string) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(seed))
+	h.Write(buf[:])
+	h.Write([]byte(id))
+	return h.Sum64()
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+)
+
+func TestClearingPrices(t *testing.T) {
+	tests := []struct {
+		name        string
+		auctionType string
+		reserve     float64
+		floor       float64
+		// bids in rank order, the first `winners` of them win
+		bids    []float64
+		winners int
+		want    []float64
+	}{
+		{name: "first price", auctionType: AuctionFirstPrice, reserve: 0.1, bids: []float64{3, 2, 1}, winners: 2, want: []float64{3, 2}},
+		{name: "first price under the floor", auctionType: AuctionFirstPrice, reserve: 0.1, floor: 5, bids: []float64{3}, winners: 1, want: []float64{3}},
+		{name: "second price", auctionType: AuctionSecondPrice, reserve: 0.1, bids: []float64{3, 2}, winners: 1, want: []float64{2.01}},
+		{name: "second price highest loser ranked last", auctionType: AuctionSecondPrice, reserve: 0.1, bids: []float64{3, 1, 2.5}, winners: 1, want: []float64{2.51}},
+		{name: "second price capped by the bid", auctionType: AuctionSecondPrice, reserve: 0.1, bids: []float64{1, 4, 2}, winners: 1, want: []float64{1}},
+		{name: "second price all winners pay the same", auctionType: AuctionSecondPrice, reserve: 0.1, bids: []float64{3, 5, 1, 2}, winners: 2, want: []float64{2.01, 2.01}},
+		{name: "second price without losers", auctionType: AuctionSecondPrice, reserve: 0.5, bids: []float64{3}, winners: 1, want: []float64{0.5}},
+		{name: "second price under the reserve", auctionType: AuctionSecondPrice, reserve: 1, bids: []float64{3, 0.2}, winners: 1, want: []float64{1}},
+		{name: "second price under the floor", auctionType: AuctionSecondPrice, reserve: 0.1, floor: 1.5, bids: []float64{3, 1}, winners: 1, want: []float64{1.5}},
+		{name: "gsp", auctionType: AuctionGSP, reserve: 0.1, bids: []float64{3, 2, 1}, winners: 2, want: []float64{2.01, 1.01}},
+		{name: "gsp highest bid ranked below", auctionType: AuctionGSP, reserve: 0.1, bids: []float64{4, 1, 3}, winners: 2, want: []float64{3.01, 1}},
+		{name: "gsp without losers", auctionType: AuctionGSP, reserve: 0.2, bids: []float64{3, 2}, winners: 2, want: []float64{2.01, 0.2}},
+		{name: "gsp under the floor", auctionType: AuctionGSP, reserve: 0.1, floor: 1.5, bids: []float64{3, 2, 1}, winners: 2, want: []float64{2.01, 1.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &AdService{cfg: &config.Config{Auction: config.AuctionConfig{Type: tt.auctionType, PriceIncrement: 0.01, ReservePrice: tt.reserve}}}
+			ranked := make([]*model.LineItem, len(tt.bids))
+			for i, bid := range tt.bids {
+				ranked[i] = &model.LineItem{ID: fmt.Sprintf("li-%d", i), Bid: bid}
+			}
+			if got := s.clearingPrices(ranked, tt.winners, tt.floor); !slices.Equal(got, tt.want) {
+				t.Errorf("clearingPrices = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankBefore(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	older := &model.LineItem{ID: "li-b", Bid: 2, CreatedAt: created}
+	newer := &model.LineItem{ID: "li-a", Bid: 2, CreatedAt: created.Add(time.Second)}
+	sameTime := &model.LineItem{ID: "li-c", Bid: 2, CreatedAt: created}
+	higherBid := &model.LineItem{ID: "li-d", Bid: 3, CreatedAt: created.Add(time.Hour)}
+	score := map[string]float64{"li-a": 1, "li-b": 1, "li-c": 1, "li-d": 1, "li-top": 2}
+	top := &model.LineItem{ID: "li-top", Bid: 0.1, CreatedAt: created.Add(time.Hour)}
+
+	tests := []struct {
+		name string
+		a, b *model.LineItem
+		want bool
+	}{
+		{name: "higher score", a: top, b: higherBid, want: true},
+		{name: "lower score", a: higherBid, b: top, want: false},
+		{name: "same score higher bid", a: higherBid, b: older, want: true},
+		{name: "same score and bid older first", a: older, b: newer, want: true},
+		{name: "same score and bid newer after", a: newer, b: older, want: false},
+		{name: "same creation time by id", a: older, b: sameTime, want: true},
+		{name: "same creation time by id reversed", a: sameTime, b: older, want: false},
+		{name: "not before itself", a: older, b: older, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankBefore(tt.a, tt.b, score, 0); got != tt.want {
+				t.Errorf("rankBefore(%s, %s) = %v, want %v", tt.a.ID, tt.b.ID, got, tt.want)
+			}
+		})
+	}
+}
+
+// with a seed the ties are a total order too, the same seed always gives it back and other seeds rotate it
+func TestTieBeforeSeeded(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tied := make([]*model.LineItem, 5)
+	for i := range tied {
+		tied[i] = &model.LineItem{ID: fmt.Sprintf("li-%d", i), Bid: 2, CreatedAt: created}
+	}
+	order := func(seed int64) []string {
+		items := slices.Clone(tied)
+		slices.SortFunc(items, func(a, b *model.LineItem) int {
+			if tieBefore(a, b, seed) {
+				return -1
+			}
+			if tieBefore(b, a, seed) {
+				return 1
+			}
+			return 0
+		})
+		ids := make([]string, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+		return ids
+	}
+
+	if got, want := order(0), []string{"li-0", "li-1", "li-2", "li-3", "li-4"}; !slices.Equal(got, want) {
+		t.Errorf("unseeded order %v, want %v", got, want)
+	}
+	firsts := map[string]bool{}
+	for seed := int64(1); seed <= 50; seed++ {
+		first := order(seed)
+		if again := order(seed); !slices.Equal(first, again) {
+			t.Fatalf("seed %d: order %v then %v", seed, first, again)
+		}
+		firsts[first[0]] = true
+	}
+	if len(firsts) < len(tied) {
+		t.Errorf("50 seeds put only %d of %d tied line items first", len(firsts), len(tied))
+	}
+}
+
+func TestAuctionReproducible(t *testing.T) {
+	items := make([]model.LineItemCreate, 6)
+	for i := range items {
+		items[i] = testLineItem(fmt.Sprintf("tied-%d", i), fmt.Sprintf("adv-%d", i), 2)
+	}
+	s, _ := newTestAdService(t, testAdConfig(t), items...)
+	orders := map[string]bool{}
+	for _, seed := range []int64{0, 1, 2, 3, 4, 5, 6, 7} {
+		query := model.WinningAdsQuery{Placement: "homepage_top", Keyword: "shoes", Limit: 3, Seed: seed}
+		first, _ := s.GetAd(query)
+		for range 20 {
+			again, _ := s.GetAd(query)
+			if !slices.Equal(adNames(again), adNames(first)) {
+				t.Fatalf("seed %d: ads %v then %v", seed, adNames(first), adNames(again))
+			}
+		}
+		orders[fmt.Sprint(adNames(first))] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("every seed served the same ads %v", orders)
+	}
+}
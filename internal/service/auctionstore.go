@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+/*
+ AuctionStore is a short-lived record of served auctions, auction id -> placement, context and winning line items.
+ Tracking events carrying an auction_id are checked against it, an impression or click for an auction that never
+ happened (or for a line item that didn't win it) is rejected. Entries live APP_TRACKING_AUCTION_TTL, a janitor drops the
+ expired ones so the map stays as big as the traffic of the last TTL.
+ Conversions come hours or days after the auction, long after the entry is gone: the click redirect hands the landing
+ page a signed conversion token that attributes them (see TrackingHandler), without one a conversion's auction_id is
+ only accepted while the auction is remembered.
+ The price of an event is always the clearing price the auction stored, never what the client sends. Events without
+ auction_id can't be tied to an auction, they are unverified and book no spend.
+ The context goes along with the tracking events of the auction, see model.TrackingMessage.
+*/
+
+var (
+	ErrAuctionIDRequired    = errors.New("auction id is required")
+	ErrUnknownAuction       = errors.New("unknown or expired auction")
+	ErrLineItemNotInAuction = errors.New("line item was not served in the auction")
+	ErrPlacementMismatch    = errors.New("placement does not match the auction")
+)
+
+var (
+	adsServed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ads_served_total",
+			Help: "Number of ads returned by auctions.",
+		},
+		[]string{"placement"},
+	)
+
+	adsServedImpressions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ads_served_impressions_total",
+			Help: "Number of served ads with at least one impression, divided by ads_served_total it's the win-to-impression rate.",
+		},
+		[]string{"placement"},
+	)
+)
+
+type servedAd struct {
+	position  int
+	price     float64
+	explored  bool
+	impressed bool
+}
+
+type servedAuction struct {
+	placement string
+	context   model.AuctionContext
+	// by line item
+	ads       map[string]*servedAd
+	expiresAt time.Time
+}
+
+type AuctionStore struct {
+	log      *zap.SugaredLogger
+	mu       sync.Mutex
+	auctions map[string]*servedAuction
+	ttl      time.Duration
+	require  bool
+}
+
+func NewAuctionStore(log *zap.SugaredLogger, cfg *config.Config) *AuctionStore {
+	return &AuctionStore{
+		log:      log,
+		auctions: map[string]*servedAuction{},
+		ttl:      cfg.Tracking.AuctionTTL,
+		require:  cfg.Tracking.RequireAuctionID,
+	}
+}
+
+// Start runs the janitor, it never returns
+func (s *AuctionStore) Start() {
+	ticker := time.NewTicker(max(s.ttl/2, time.Second))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.mu.Lock()
+		for id, auction := range s.auctions {
+			if now.After(auction.expiresAt) {
+				delete(s.auctions, id)
+			}
+		}
+		size := len(s.auctions)
+		s.mu.Unlock()
+		s.log.Debugw("Expired auctions dropped", "auctions", size)
+	}
+}
+
+// Record remembers the winners of the auction and its context, auctions without winners are not stored
+func (s *AuctionStore) Record(auctionID, placement string, winners []*model.Ad, context model.AuctionContext) {
+	if len(winners) == 0 {
+		return
+	}
+	ads := make(map[string]*servedAd, len(winners))
+	for i, ad := range winners {
+		ads[ad.ID] = &servedAd{position: i + 1, price: ad.Price, explored: ad.Explored}
+	}
+	s.mu.Lock()
+	s.auctions[auctionID] = &servedAuction{
+		placement: placement,
+		context:   context,
+		ads:       ads,
+		expiresAt: time.Now().Add(s.ttl),
+	}
+	s.mu.Unlock()
+	adsServed.WithLabelValues(placement).Add(float64(len(winners)))
+}
+
+// Context is the context of the auction the line item won, nil once the auction expired
+func (s *AuctionStore) Context(auctionID, lineItemID string) *model.AuctionContext {
+	if auctionID == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	auction, ok := s.auctions[auctionID]
+	if !ok {
+		return nil
+	}
+	ad, ok := auction.ads[lineItemID]
+	if !ok {
+		return nil
+	}
+	context := auction.context
+	context.Position, context.Price, context.Explored = ad.position, ad.price, ad.explored
+	return &context
+}
+
+// Check verifies the event belongs to a served auction and returns the clearing price of the ad in it.
+// Events without auction id pass unverified with no price, unless APP_TRACKING_REQUIRE_AUCTION_ID is set (conversions excepted).
+// Conversions have no price and happen anywhere, not on the placement
+func (s *AuctionStore) Check(event model.TrackingEvent) (float64, error) {
+	conversion := event.EventType == model.TrackingEventTypeConversion
+	if event.AuctionID == "" {
+		if s.require && !conversion {
+			return 0, ErrAuctionIDRequired
+		}
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	auction, ok := s.auctions[event.AuctionID]
+	if !ok || time.Now().After(auction.expiresAt) {
+		return 0, ErrUnknownAuction
+	}
+	ad, ok := auction.ads[event.LineItemID]
+	if !ok {
+		return 0, ErrLineItemNotInAuction
+	}
+	if conversion {
+		return 0, nil
+	}
+	if auction.placement != event.Placement {
+		return 0, ErrPlacementMismatch
+	}
+	return ad.price, nil
+}
+
+// MarkImpression counts the first impression of a served ad, repeated pixels of the same ad are counted once
+func (s *AuctionStore) MarkImpression(auctionID, lineItemID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	auction, ok := s.auctions[auctionID]
+	if !ok {
+		return
+	}
+	if ad, ok := auction.ads[lineItemID]; ok && !ad.impressed {
+		ad.impressed = true
+		adsServedImpressions.WithLabelValues(auction.placement).Inc()
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"sweng-task/internal/model"
+)
+
+/*
+ Batch ads run one auction per slot, but the slots know about each other through pageAuction: a line item already
+ placed on the page can't win another slot, and advertiser caps and competitive separation count the whole page.
+ Slots go in request order, so earlier slots get the first pick. Keywords, categories, user and geo are shared.
+*/
+
+type pageAuction struct {
+	served     map[string]bool
+	separation *separation
+}
+
+func newPageAuction(maxPerAdvertiser int) *pageAuction {
+	return &pageAuction{
+		served:     map[string]bool{},
+		separation: newSeparation(maxPerAdvertiser),
+	}
+}
+
+func (p *pageAuction) hasServed(id string) bool {
+	return p != nil && p.served[id]
+}
+
+// add books the winners of a slot, nil page (single placement request) does nothing
+func (p *pageAuction) add(winners []*model.LineItem, competitive map[string][]string) {
+	if p == nil {
+		return
+	}
+	for _, item := range winners {
+		p.served[item.ID] = true
+		p.separation.add(item.AdvertiserID, competitive[item.ID])
+	}
+}
+
+// GetAdsBatch fills every slot of the page, device comes from the User-Agent of the page request
+func (s *AdService) GetAdsBatch(request model.BatchAdsRequest, device model.Device) []*model.SlotAds {
+	maxPerAdvertiser := request.MaxPerAdvertiser
+	if maxPerAdvertiser == 0 {
+		maxPerAdvertiser = s.cfg.Auction.MaxAdsPerAdvertiser
+	}
+	page := newPageAuction(maxPerAdvertiser)
+	pageContext := request.Context
+	result := make([]*model.SlotAds, 0, len(request.Slots))
+	for _, slot := range request.Slots {
+		ads, _ := s.auction(model.WinningAdsQuery{
+			Placement:  slot.Placement,
+			Keywords:   pageContext.Keywords,
+			Categories: pageContext.Categories,
+			Limit:      slot.Limit,
+			Seed:       pageContext.Seed,
+			UserID:     pageContext.UserID,
+			Country:    pageContext.Country,
+			Region:     pageContext.Region,
+			Lat:        pageContext.Lat,
+			Lon:        pageContext.Lon,
+			Device:     device,
+		}, page, nil)
+		result = append(result, &model.SlotAds{SlotID: slot.ID, Placement: slot.Placement, Ads: ads})
+	}
+	s.logs.Debugw("Batch ads served", "slots", len(request.Slots), "ads", len(page.served))
+	return result
+}
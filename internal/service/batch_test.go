@@ -0,0 +1,101 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+func footerLineItem(name string, advertiserID string, bid float64) model.LineItemCreate {
+	item := testLineItem(name, advertiserID, bid)
+	item.Placement = "footer_banner"
+	return item
+}
+
+func TestGetAdsBatch(t *testing.T) {
+	homepage := []model.LineItemCreate{
+		testLineItem("a", "adv-1", 5),
+		testLineItem("b", "adv-1", 4),
+		testLineItem("c", "adv-2", 3),
+	}
+	top := func(id string, limit int) model.AdSlot {
+		return model.AdSlot{ID: id, Placement: "homepage_top", Limit: limit}
+	}
+	tests := []struct {
+		name             string
+		items            []model.LineItemCreate
+		slots            []model.AdSlot
+		maxPerAdvertiser int
+		// ads of every slot, in slot order
+		want [][]string
+	}{
+		{
+			name:  "no line item twice on the page",
+			items: homepage,
+			slots: []model.AdSlot{top("s1", 1), top("s2", 1), top("s3", 2)},
+			want:  [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:  "earlier slots pick first",
+			items: homepage,
+			slots: []model.AdSlot{top("s1", 2), top("s2", 2)},
+			want:  [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:             "advertiser cap counts the whole page",
+			items:            homepage,
+			slots:            []model.AdSlot{top("s1", 1), top("s2", 1), top("s3", 1)},
+			maxPerAdvertiser: 1,
+			want:             [][]string{{"a"}, {"c"}, {}},
+		},
+		{
+			name: "competitive separation across placements",
+			items: []model.LineItemCreate{
+				competing(testLineItem("airline-1", "adv-1", 5), "airline"),
+				competing(footerLineItem("airline-2", "adv-2", 5), "airline"),
+				footerLineItem("hotel", "adv-3", 1),
+			},
+			slots: []model.AdSlot{top("top", 1), {ID: "footer", Placement: "footer_banner"}},
+			want:  [][]string{{"airline-1"}, {"hotel"}},
+		},
+		{
+			name:  "unknown placement",
+			items: homepage,
+			slots: []model.AdSlot{{ID: "sidebar", Placement: "homepage_sidebar"}, top("s1", 1)},
+			want:  [][]string{{}, {"a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestAdService(t, testAdConfig(t), tt.items...)
+			result := s.GetAdsBatch(model.BatchAdsRequest{
+				Context:          model.PageContext{Keywords: []string{"shoes"}},
+				Slots:            tt.slots,
+				MaxPerAdvertiser: tt.maxPerAdvertiser,
+			}, model.Device{})
+			if len(result) != len(tt.slots) {
+				t.Fatalf("%d slots in the response, want %d", len(result), len(tt.slots))
+			}
+			auctionIDs, filled := map[string]bool{}, 0
+			for i, slot := range result {
+				if slot.SlotID != tt.slots[i].ID || slot.Placement != tt.slots[i].Placement {
+					t.Errorf("slot %d is %s (%s), want %s (%s)", i, slot.SlotID, slot.Placement, tt.slots[i].ID, tt.slots[i].Placement)
+				}
+				if got := adNames(slot.Ads); !slices.Equal(got, tt.want[i]) {
+					t.Errorf("slot %s: ads %v, want %v", slot.SlotID, got, tt.want[i])
+				}
+				if len(slot.Ads) > 0 {
+					filled++
+				}
+				for _, ad := range slot.Ads {
+					auctionIDs[ad.AuctionID] = true
+				}
+			}
+			// every slot is its own auction
+			if len(auctionIDs) != filled {
+				t.Errorf("%d auction ids for %d filled slots", len(auctionIDs), filled)
+			}
+		})
+	}
+}
@@ -10,27 +10,40 @@ import (
 */
 
 type Cache struct {
-	log       *zap.SugaredLogger
-	runTimeDB *RunTimeDB
-	lit       *LineItemService
+	log        *zap.SugaredLogger
+	runTimeDB  *RunTimeDB
+	lit        *LineItemService
+	normalizer *Normalizer
 }
 
-func NewDataProcessorService(log *zap.SugaredLogger, db *RunTimeDB, lit *LineItemService) *Cache {
+func NewDataProcessorService(log *zap.SugaredLogger, db *RunTimeDB, lit *LineItemService, normalizer *Normalizer) *Cache {
 	return &Cache{
-		log:       log,
-		runTimeDB: db,
-		lit:       lit,
+		log:        log,
+		runTimeDB:  db,
+		lit:        lit,
+		normalizer: normalizer,
 	}
 }
 
 func (d *Cache) PopulateCache() {
 	for id, item := range d.lit.items {
-		d.runTimeDB.AddKeyWords(item.Keywords, id)
-		d.runTimeDB.AddCategory(item.Categories, id)
+		// index has to hold the normalized terms, query side runs the same normalizer before the lookup
+		keywords := d.normalizer.NormalizeAll(item.Keywords)
+		categories := d.normalizer.NormalizeAll(item.Categories)
+		d.runTimeDB.AddKeyWords(keywords, id)
+		d.runTimeDB.AddCategory(categories, id)
 		d.runTimeDB.AddPlacements(item.Placement, id)
-		totalParam := len(item.Categories) + len(item.Keywords)
+		d.runTimeDB.AddPartialMatch(id, item.PrefixMatch, item.FuzzyMatch)
+		d.runTimeDB.AddGeo(item.Geo, id)
+		d.runTimeDB.DeviceTypes.Add(item.DeviceTypes, id)
+		d.runTimeDB.OperatingSystems.Add(item.OperatingSystems, id)
+		d.runTimeDB.Browsers.Add(item.Browsers, id)
+		d.runTimeDB.AddCompetitiveCategories(d.normalizer.NormalizeAll(item.CompetitiveCategories), id)
+		d.runTimeDB.AddExcludedKeywords(d.normalizer.NormalizeAll(item.ExcludedKeywords), id)
+		d.runTimeDB.AddExcludedCategories(d.normalizer.NormalizeAll(item.ExcludedCategories), id)
+		totalParam := len(categories) + len(keywords)
 		d.runTimeDB.AddParameterCount(id, totalParam)
-		if len(item.Keywords) == 0 && len(item.Categories) == 0 {
+		if len(keywords) == 0 && len(categories) == 0 {
 			d.runTimeDB.AddTargetFree(id)
 		}
 	}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"sync"
+
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+/*
+ CTREstimator keeps an online click-through-rate estimate per line item and placement, fed by the verified tracking
+ events (signed tracking URLs or the auction_id of a served auction), anyone could post clicks otherwise.
+ Raw clicks/impressions is useless for new line items (0/0, or 1/1 = 100% after the first lucky click), so the
+ estimate is smoothed with a Beta prior: it starts at the prior CTR and moves to the observed CTR as impressions come in.
+ PriorWeight is how many impressions the prior is worth.
+*/
+
+// Ranking modes, selected per placement with APP_RANKING_MODES
+const (
+	// RankingRelevance orders by the targeting relevance score, highest bidder gets the bidWeight bonus
+	RankingRelevance = "relevance"
+	// RankingECPM orders relevant line items by bid × pCTR × 1000
+	RankingECPM = "ecpm"
+)
+
+type ctrKey struct {
+	lineItemID string
+	placement  string
+}
+
+type ctrStats struct {
+	impressions float64
+	clicks      float64
+}
+
+type CTREstimator struct {
+	log   *zap.SugaredLogger
+	mu    sync.RWMutex
+	stats map[ctrKey]*ctrStats
+	// Beta(alpha, beta) prior
+	alpha float64
+	beta  float64
+}
+
+func NewCTREstimator(log *zap.SugaredLogger, cfg *config.Config) *CTREstimator {
+	return &CTREstimator{
+		log:   log,
+		stats: map[ctrKey]*ctrStats{},
+		alpha: cfg.Ranking.PriorCTR * cfg.Ranking.PriorWeight,
+		beta:  (1 - cfg.Ranking.PriorCTR) * cfg.Ranking.PriorWeight,
+	}
+}
+
+// Record counts impressions and clicks, other events don't change the CTR
+func (e *CTREstimator) Record(event model.TrackingEvent) {
+	if event.EventType != model.TrackingEventTypeImpression && event.EventType != model.TrackingEventTypeClick {
+		return
+	}
+	key := ctrKey{lineItemID: event.LineItemID, placement: event.Placement}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats, ok := e.stats[key]
+	if !ok {
+		stats = &ctrStats{}
+		e.stats[key] = stats
+	}
+	if event.EventType == model.TrackingEventTypeImpression {
+		stats.impressions++
+	} else {
+		stats.clicks++
+	}
+}
+
+// Predict returns the posterior mean CTR, (clicks + alpha) / (impressions + alpha + beta)
+func (e *CTREstimator) Predict(lineItemID string, placement string) float64 {
+	impressions, clicks := e.Stats(lineItemID, placement)
+	// clicks can arrive without their impression (lost pixel), CTR can't go over 1
+	clicks = min(clicks, impressions)
+	return (clicks + e.alpha) / (impressions + e.alpha + e.beta)
+}
+
+// Stats returns the raw counters of a line item on a placement
+func (e *CTREstimator) Stats(lineItemID string, placement string) (impressions float64, clicks float64) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stats, ok := e.stats[ctrKey{lineItemID: lineItemID, placement: placement}]
+	if !ok {
+		return 0, 0
+	}
+	return stats.impressions, stats.clicks
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"go.uber.org/zap"
+	"strings"
 	"sweng-task/internal/model"
 )
 
@@ -51,6 +52,18 @@ func (d *DataGeneratorService) generateSingleLineItem(name string, advID string,
 		Categories:   categories,
 		Keywords:     keywords,
 	}
+	// video line items can only be served over VAST with a creative
+	if placement == "video_preroll" {
+		slug := strings.ReplaceAll(strings.ToLower(name), " ", "-")
+		input.Creative = &model.Creative{
+			MediaFiles: []model.MediaFile{
+				{URL: "https://content.realtimemediatool.com/video/" + slug + "-720p.mp4", MimeType: "video/mp4", Width: 1280, Height: 720, Bitrate: 2500},
+				{URL: "https://content.realtimemediatool.com/video/" + slug + "-360p.mp4", MimeType: "video/mp4", Width: 640, Height: 360, Bitrate: 800},
+			},
+			Duration:        15,
+			ClickThroughURL: "https://content.realtimemediatool.com/landing/" + slug,
+		}
+	}
 	_, err := d.lis.Create(input)
 	if err != nil {
 		d.log.Error("Failed to create lineItem", zap.Error(err))
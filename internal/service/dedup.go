@@ -0,0 +1,181 @@
+package service
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+/*
+ Deduplicator drops retried tracking events before they are published. Browsers and SDKs retry on timeouts, without
+ it every retry ends up in ads_final as one more impression or click.
+ Event ids are kept in a rotating bloom filter: two generations, each collecting APP_TRACKING_DEDUP_WINDOW of events.
+ Lookups check both, inserts go to the current one, and when it is older than the window the previous one is thrown
+ away and a fresh one takes its place. So an id is remembered at least one window (at most two) and memory stays at
+ two filters sized for APP_TRACKING_DEDUP_CAPACITY events per window, whatever the traffic.
+ Handlers Reserve the event id before publishing: a concurrent retry of an event being published is a duplicate too.
+ The id is only remembered (Commit) once the event was published, an event kafka didn't take is Released and the
+ retry of the client is not taken for a duplicate.
+ A bloom filter never misses a duplicate, but a new event can be taken for one with APP_TRACKING_DEDUP_FALSE_POSITIVE_RATE,
+ as long as the window sees no more events than the capacity.
+*/
+
+var duplicateEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "tracking_duplicate_events_total",
+		Help: "Number of tracking events acknowledged but not published because their event id was already seen.",
+	},
+	[]string{"event_type"},
+)
+
+type bloomFilter struct {
+	bits    []uint64
+	size    uint64
+	hashes  uint64
+	created time.Time
+}
+
+func newBloomFilter(capacity int, falsePositiveRate float64) *bloomFilter {
+	// optimal size and number of hashes for n items at false positive rate p: m = -n ln p / ln²2, k = m/n ln 2
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	size = max(size, 64)
+	hashes := uint64(math.Max(1, math.Round(float64(size)/float64(capacity)*math.Ln2)))
+	return &bloomFilter{
+		bits:    make([]uint64, (size+63)/64),
+		size:    size,
+		hashes:  hashes,
+		created: time.Now(),
+	}
+}
+
+// positions of the key, k hashes derived from two halves of one 64 bit hash (Kirsch-Mitzenmacher)
+func (b *bloomFilter) positions(key string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	positions := make([]uint64, b.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % b.size
+	}
+	return positions
+}
+
+func (b *bloomFilter) contains(positions []uint64) bool {
+	for _, p := range positions {
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) add(positions []uint64) {
+	for _, p := range positions {
+		b.bits[p/64] |= 1 << (p % 64)
+	}
+}
+
+type Deduplicator struct {
+	log               *zap.SugaredLogger
+	mu                sync.Mutex
+	window            time.Duration
+	capacity          int
+	falsePositiveRate float64
+	current           *bloomFilter
+	previous          *bloomFilter
+	// event ids reserved while their event is published
+	inFlight map[string]struct{}
+}
+
+func NewDeduplicator(log *zap.SugaredLogger, cfg *config.Config) *Deduplicator {
+	d := &Deduplicator{
+		log:               log,
+		window:            cfg.Tracking.DedupWindow,
+		capacity:          cfg.Tracking.DedupCapacity,
+		falsePositiveRate: cfg.Tracking.DedupFalsePositiveRate,
+		inFlight:          map[string]struct{}{},
+	}
+	if d.window > 0 {
+		d.current = newBloomFilter(d.capacity, d.falsePositiveRate)
+		d.previous = newBloomFilter(d.capacity, d.falsePositiveRate)
+	}
+	return d
+}
+
+// EventID is the event_id of the event, events without one are identified by auction, line item and event type:
+// an ad of an auction is impressed (or clicked, completed...) once. Events with neither have no id and are never duplicates
+func EventID(event model.TrackingEvent) string {
+	if event.EventID != "" {
+		return event.EventID
+	}
+	if event.AuctionID == "" {
+		return ""
+	}
+	return event.AuctionID + ":" + event.LineItemID + ":" + string(event.EventType)
+}
+
+// Reserve reports whether the event is new and reserves its id until Commit or Release. Events seen within the
+// window or being published are duplicates and counted in tracking_duplicate_events_total
+func (d *Deduplicator) Reserve(event model.TrackingEvent) bool {
+	if d.current == nil || event.EventID == "" {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, inFlight := d.inFlight[event.EventID]
+	if inFlight || d.contains(event.EventID) {
+		duplicateEvents.WithLabelValues(string(event.EventType)).Inc()
+		d.log.Debugw("Duplicate tracking event", "event_id", event.EventID, "event_type", event.EventType)
+		return false
+	}
+	d.inFlight[event.EventID] = struct{}{}
+	return true
+}
+
+// Commit remembers the reserved event id, the event was published
+func (d *Deduplicator) Commit(event model.TrackingEvent) {
+	if d.current == nil || event.EventID == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inFlight, event.EventID)
+	d.rotate()
+	d.current.add(d.current.positions(event.EventID))
+}
+
+// Release drops the reservation of an event that wasn't published, its retry is a new event
+func (d *Deduplicator) Release(event model.TrackingEvent) {
+	if d.current == nil || event.EventID == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inFlight, event.EventID)
+}
+
+// contains checks both generations for the id, callers hold the lock
+func (d *Deduplicator) contains(id string) bool {
+	d.rotate()
+	positions := d.current.positions(id)
+	return d.current.contains(positions) || d.previous.contains(positions)
+}
+
+// rotate replaces the generations that are out of the window, callers hold the lock
+func (d *Deduplicator) rotate() {
+	if age := time.Since(d.current.created); age > 2*d.window {
+		// nothing came in for a while, both generations are out of the window
+		d.previous, d.current = newBloomFilter(d.capacity, d.falsePositiveRate), newBloomFilter(d.capacity, d.falsePositiveRate)
+	} else if age > d.window {
+		d.previous, d.current = d.current, newBloomFilter(d.capacity, d.falsePositiveRate)
+	}
+}
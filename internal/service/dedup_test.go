@@ -0,0 +1,176 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+)
+
+func newTestDeduplicator(window time.Duration, capacity int, falsePositiveRate float64) *Deduplicator {
+	cfg := &config.Config{}
+	cfg.Tracking.DedupWindow = window
+	cfg.Tracking.DedupCapacity = capacity
+	cfg.Tracking.DedupFalsePositiveRate = falsePositiveRate
+	return NewDeduplicator(zap.NewNop().Sugar(), cfg)
+}
+
+func dedupTestEvent(id string) model.TrackingEvent {
+	return model.TrackingEvent{EventID: id, EventType: model.TrackingEventTypeImpression}
+}
+
+// published reserves and commits the event, true when it was new
+func published(d *Deduplicator, id string) bool {
+	if !d.Reserve(dedupTestEvent(id)) {
+		return false
+	}
+	d.Commit(dedupTestEvent(id))
+	return true
+}
+
+func TestDeduplicatorReserve(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		// reserve, commit or release of the same event
+		calls []string
+		// results of the reserve calls
+		want []bool
+	}{
+		{name: "first event is new", window: time.Minute, calls: []string{"reserve"}, want: []bool{true}},
+		{name: "event being published is a duplicate", window: time.Minute, calls: []string{"reserve", "reserve"}, want: []bool{true, false}},
+		{name: "retry of a published event is a duplicate", window: time.Minute, calls: []string{"reserve", "commit", "reserve", "reserve"}, want: []bool{true, false, false}},
+		{name: "retry of a released event is new", window: time.Minute, calls: []string{"reserve", "release", "reserve"}, want: []bool{true, true}},
+		{name: "release after commit keeps the event", window: time.Minute, calls: []string{"reserve", "commit", "release", "reserve"}, want: []bool{true, false}},
+		{name: "disabled", window: 0, calls: []string{"reserve", "commit", "reserve"}, want: []bool{true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDeduplicator(tt.window, 1000, 0.001)
+			got := []bool{}
+			for _, call := range tt.calls {
+				switch call {
+				case "reserve":
+					got = append(got, d.Reserve(dedupTestEvent("event")))
+				case "commit":
+					d.Commit(dedupTestEvent("event"))
+				case "release":
+					d.Release(dedupTestEvent("event"))
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Reserve = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("events without id", func(t *testing.T) {
+		d := newTestDeduplicator(time.Minute, 1000, 0.001)
+		for range 3 {
+			if !published(d, "") {
+				t.Fatal("event without id taken for a duplicate")
+			}
+		}
+	})
+}
+
+func TestDeduplicatorRotation(t *testing.T) {
+	const window = time.Minute
+	tests := []struct {
+		name string
+		// age of the current generation before each later event, an event older than the window rotates the generations
+		ages []time.Duration
+		want bool
+	}{
+		{name: "within the window", ages: []time.Duration{window / 2}, want: true},
+		{name: "kept in the previous generation", ages: []time.Duration{window + time.Second}, want: true},
+		{name: "expired after two rotations", ages: []time.Duration{window + time.Second, window + time.Second}, want: false},
+		{name: "expired after two windows without events", ages: []time.Duration{2*window + time.Second}, want: false},
+		{name: "rotation keeps the previous generation", ages: []time.Duration{window / 2, window + time.Second, window / 2}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDeduplicator(window, 1000, 0.001)
+			if !published(d, "event") {
+				t.Fatal("first event taken for a duplicate")
+			}
+			for i, age := range tt.ages {
+				d.current.created = time.Now().Add(-age)
+				published(d, fmt.Sprintf("other-%d", i))
+			}
+			if got := !d.Reserve(dedupTestEvent("event")); got != tt.want {
+				t.Errorf("duplicate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeduplicatorFalsePositiveRate(t *testing.T) {
+	tests := []struct {
+		name              string
+		capacity          int
+		falsePositiveRate float64
+	}{
+		{name: "1%", capacity: 10000, falsePositiveRate: 0.01},
+		{name: "0.1%", capacity: 20000, falsePositiveRate: 0.001},
+		{name: "small filter", capacity: 100, falsePositiveRate: 0.05},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDeduplicator(time.Hour, tt.capacity, tt.falsePositiveRate)
+			for i := range tt.capacity {
+				published(d, fmt.Sprintf("known-%d", i))
+			}
+			// a bloom filter never misses what it holds
+			for i := range tt.capacity {
+				if d.Reserve(dedupTestEvent(fmt.Sprintf("known-%d", i))) {
+					t.Fatalf("known-%d not seen", i)
+				}
+			}
+
+			checks := max(100000, tt.capacity*10)
+			falsePositives := 0
+			for i := range checks {
+				if !d.Reserve(dedupTestEvent(fmt.Sprintf("new-%d", i))) {
+					falsePositives++
+				}
+			}
+			rate := float64(falsePositives) / float64(checks)
+			if rate > 2*tt.falsePositiveRate {
+				t.Errorf("false positive rate at capacity = %.4f, configured %.4f", rate, tt.falsePositiveRate)
+			}
+		})
+	}
+}
+
+func TestDeduplicatorConcurrentReserve(t *testing.T) {
+	const (
+		events     = 100
+		goroutines = 16
+	)
+	d := newTestDeduplicator(time.Minute, 10000, 0.001)
+	var firsts [events]atomic.Int32
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range events {
+				if published(d, fmt.Sprintf("event-%d", i)) {
+					firsts[i].Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i := range events {
+		if got := firsts[i].Load(); got != 1 {
+			t.Errorf("event-%d taken for new %d times, want once", i, got)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Errors
+var (
+	ErrExperimentNotFound    = errors.New("experiment not found")
+	ErrInvalidExperiment     = errors.New("invalid experiment")
+	ErrOverlappingExperiment = errors.New("a running experiment already covers this placement")
+)
+
+/*
+ ExperimentService runs A/B tests of the ad selection. A user is bucketed by hashing experiment id + user_id, so the same
+ user always gets the same variant of an experiment (no flickering between requests) and buckets of different experiments
+ are independent of each other. Only one running experiment can cover a placement, overlapping experiments on the same
+ traffic would make the comparison meaningless. Requests without user_id are not enrolled and use the defaults.
+*/
+
+type ExperimentService struct {
+	log         *zap.SugaredLogger
+	mu          sync.RWMutex
+	experiments map[string]*model.Experiment
+}
+
+func NewExperimentService(log *zap.SugaredLogger) *ExperimentService {
+	return &ExperimentService{
+		log:         log,
+		experiments: map[string]*model.Experiment{},
+	}
+}
+
+// Create validates the variants and starts the experiment right away
+func (s *ExperimentService) Create(input model.ExperimentCreate) (*model.Experiment, error) {
+	variantIDs := map[string]bool{}
+	for _, variant := range input.Variants {
+		if variantIDs[variant.ID] {
+			return nil, fmt.Errorf("%w: duplicate variant id %s", ErrInvalidExperiment, variant.ID)
+		}
+		variantIDs[variant.ID] = true
+		for weight := range variant.Scoring {
+			if _, ok := CoreScoring[weight]; !ok {
+				return nil, fmt.Errorf("%w: unknown scoring weight %s in variant %s", ErrInvalidExperiment, weight, variant.ID)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, running := range s.experiments {
+		if running.Status != model.ExperimentStatusRunning {
+			continue
+		}
+		if running.Placement == "" || input.Placement == "" || running.Placement == input.Placement {
+			return nil, fmt.Errorf("%w: %s", ErrOverlappingExperiment, running.ID)
+		}
+	}
+
+	now := time.Now()
+	experiment := &model.Experiment{
+		ID:        "exp_" + uuid.New().String(),
+		Name:      input.Name,
+		Placement: input.Placement,
+		Variants:  input.Variants,
+		Status:    model.ExperimentStatusRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.experiments[experiment.ID] = experiment
+	s.log.Infow("Experiment started",
+		"id", experiment.ID,
+		"name", experiment.Name,
+		"placement", experiment.Placement,
+		"variants", len(experiment.Variants),
+	)
+	created := *experiment
+	return &created, nil
+}
+
+// GetByID retrieves a copy of an experiment by ID, Stop changes the stored one
+func (s *ExperimentService) GetByID(id string) (*model.Experiment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	experiment, ok := s.experiments[id]
+	if !ok {
+		return nil, ErrExperimentNotFound
+	}
+	found := *experiment
+	return &found, nil
+}
+
+// GetAll returns a copy of every experiment, newest first
+func (s *ExperimentService) GetAll() []*model.Experiment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*model.Experiment, 0, len(s.experiments))
+	for _, experiment := range s.experiments {
+		found := *experiment
+		result = append(result, &found)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].CreatedAt.After(result[b].CreatedAt)
+	})
+	return result
+}
+
+// Stop ends an experiment and returns a copy of it, its traffic goes back to the defaults. Stopped experiments are kept for reference
+func (s *ExperimentService) Stop(id string) (*model.Experiment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	experiment, ok := s.experiments[id]
+	if !ok {
+		return nil, ErrExperimentNotFound
+	}
+	if experiment.Status != model.ExperimentStatusStopped {
+		experiment.Status = model.ExperimentStatusStopped
+		experiment.UpdatedAt = time.Now()
+		s.log.Infow("Experiment stopped", "id", experiment.ID)
+	}
+	stopped := *experiment
+	return &stopped, nil
+}
+
+// Assign returns the variant of the running experiment covering the placement, nil when the request is not enrolled
+func (s *ExperimentService) Assign(placement string, userID string) *model.Assignment {
+	if userID == "" {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, experiment := range s.experiments {
+		if experiment.Status != model.ExperimentStatusRunning {
+			continue
+		}
+		if experiment.Placement != "" && experiment.Placement != placement {
+			continue
+		}
+		variant := bucketVariant(experiment, userID)
+		return &model.Assignment{
+			ExperimentID: experiment.ID,
+			VariantID:    variant.ID,
+			Scoring:      variant.Scoring,
+			RankingMode:  variant.RankingMode,
+		}
+	}
+	return nil
+}
+
+// bucketVariant maps hash(experiment, user) onto the cumulative variant weights
+func bucketVariant(experiment *model.Experiment, userID string) model.Variant {
+	total := 0
+	for _, variant := range experiment.Variants {
+		total += variant.Weight
+	}
+	h := fnv.New64a()
+	h.Write([]byte(experiment.ID))
+	h.Write([]byte{0})
+	h.Write([]byte(userID))
+	point := int(h.Sum64() % uint64(total))
+	for _, variant := range experiment.Variants {
+		if point < variant.Weight {
+			return variant
+		}
+		point -= variant.Weight
+	}
+	return experiment.Variants[len(experiment.Variants)-1]
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+)
+
+func newTestExperiment(t *testing.T, s *ExperimentService, placement string, weights ...int) *model.Experiment {
+	t.Helper()
+	variants := make([]model.Variant, len(weights))
+	for i, weight := range weights {
+		variants[i] = model.Variant{ID: fmt.Sprintf("v%d", i), Weight: weight}
+	}
+	experiment, err := s.Create(model.ExperimentCreate{Name: "test", Placement: placement, Variants: variants})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return experiment
+}
+
+func TestExperimentAssignIsStable(t *testing.T) {
+	s := NewExperimentService(zap.NewNop().Sugar())
+	experiment := newTestExperiment(t, s, "homepage_top", 1, 1, 1)
+	for i := range 1000 {
+		user := fmt.Sprintf("user-%d", i)
+		first := s.Assign("homepage_top", user)
+		if first == nil || first.ExperimentID != experiment.ID {
+			t.Fatalf("%s not enrolled: %+v", user, first)
+		}
+		for range 3 {
+			if again := s.Assign("homepage_top", user); again.VariantID != first.VariantID {
+				t.Fatalf("%s moved from %s to %s", user, first.VariantID, again.VariantID)
+			}
+		}
+	}
+}
+
+func TestExperimentAssign(t *testing.T) {
+	tests := []struct {
+		name         string
+		placement    string
+		request      string
+		userID       string
+		stopped      bool
+		wantEnrolled bool
+	}{
+		{name: "placement of the experiment", placement: "homepage_top", request: "homepage_top", userID: "user-1", wantEnrolled: true},
+		{name: "experiment on all placements", placement: "", request: "footer_banner", userID: "user-1", wantEnrolled: true},
+		{name: "other placement", placement: "homepage_top", request: "footer_banner", userID: "user-1", wantEnrolled: false},
+		{name: "no user", placement: "homepage_top", request: "homepage_top", userID: "", wantEnrolled: false},
+		{name: "stopped", placement: "homepage_top", request: "homepage_top", userID: "user-1", stopped: true, wantEnrolled: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewExperimentService(zap.NewNop().Sugar())
+			experiment := newTestExperiment(t, s, tt.placement, 1, 1)
+			if tt.stopped {
+				if _, err := s.Stop(experiment.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := s.Assign(tt.request, tt.userID); (got != nil) != tt.wantEnrolled {
+				t.Errorf("Assign = %+v, want enrolled %v", got, tt.wantEnrolled)
+			}
+		})
+	}
+}
+
+func TestBucketVariantWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{name: "even split", weights: []int{1, 1}},
+		{name: "90/10", weights: []int{9, 1}},
+		{name: "three arms", weights: []int{50, 30, 20}},
+	}
+	const users = 100000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			experiment := &model.Experiment{ID: "exp-" + tt.name}
+			total := 0
+			for i, weight := range tt.weights {
+				experiment.Variants = append(experiment.Variants, model.Variant{ID: fmt.Sprintf("v%d", i), Weight: weight})
+				total += weight
+			}
+			counts := map[string]int{}
+			for i := range users {
+				counts[bucketVariant(experiment, fmt.Sprintf("user-%d", i)).ID]++
+			}
+			for _, variant := range experiment.Variants {
+				want := float64(variant.Weight) / float64(total)
+				got := float64(counts[variant.ID]) / users
+				if math.Abs(got-want) > 0.01 {
+					t.Errorf("variant %s got %.3f of the users, want %.3f", variant.ID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExperimentCreateOverlap(t *testing.T) {
+	s := NewExperimentService(zap.NewNop().Sugar())
+	running := newTestExperiment(t, s, "homepage_top", 1, 1)
+	_, err := s.Create(model.ExperimentCreate{Name: "overlap", Placement: "homepage_top", Variants: running.Variants})
+	if !errors.Is(err, ErrOverlappingExperiment) {
+		t.Errorf("overlapping experiment: error %v, want %v", err, ErrOverlappingExperiment)
+	}
+	if _, err := s.Stop(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(model.ExperimentCreate{Name: "next", Placement: "homepage_top", Variants: running.Variants}); err != nil {
+		t.Errorf("experiment after the stopped one: %v", err)
+	}
+}
+
+// handlers serialize the experiments without the lock while Stop changes them, run with -race
+func TestExperimentStopReturnsCopies(t *testing.T) {
+	s := NewExperimentService(zap.NewNop().Sugar())
+	experiment := newTestExperiment(t, s, "homepage_top", 1, 1)
+	byID, err := s.GetByID(experiment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := s.GetAll()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := s.Stop(experiment.ID); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, e := range append(all, experiment, byID) {
+		if _, err := json.Marshal(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if byID.Status != model.ExperimentStatusRunning {
+		t.Errorf("copy changed by Stop: %s", byID.Status)
+	}
+	if stored, _ := s.GetByID(experiment.ID); stored.Status != model.ExperimentStatusStopped {
+		t.Errorf("stored experiment %s, want %s", stored.Status, model.ExperimentStatusStopped)
+	}
+}
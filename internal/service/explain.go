@@ -0,0 +1,151 @@
+package service
+
+import (
+	"sort"
+
+	"sweng-task/internal/model"
+)
+
+// Reasons a candidate was excluded from the auction or lost it
+const (
+	reasonOtherPlacement = "other_placement"
+	reasonExcluded       = "excluded_keyword_or_category"
+	reasonGeo            = "geo"
+	reasonDeviceType     = "device_type"
+	reasonOS             = "operating_system"
+	reasonBrowser        = "browser"
+	reasonBelowFloor     = "below_floor"
+	reasonNoCreative     = "no_video_creative"
+	reasonNoMatch        = "no_targeting_match"
+	reasonAdvertiserCap  = "advertiser_cap"
+	reasonCompetitive    = "competitive_separation"
+	reasonOutranked      = "outranked"
+	reasonAlreadyOnPage  = "already_on_page"
+	// reasonExplorationSlot lost its slot to an under-served line item, reasonExplored is the one that got it
+	reasonExplorationSlot = "displaced_by_exploration"
+	reasonExplored        = "exploration"
+)
+
+/*
+ auctionTrace collects the auction internals for the debug output. GetAd runs with a nil trace and every method is
+ a no-op on nil, so normal traffic doesn't pay anything for it.
+ A traced auction is a dry run: no exploration, no auction id, no signed tracking URLs, nothing stored in the AuctionStore
+ and no serving metrics, so explaining an auction can't be billed or show up in the numbers.
+*/
+
+type auctionTrace struct {
+	candidates map[string]*model.CandidateDebug
+}
+
+func newAuctionTrace() *auctionTrace {
+	return &auctionTrace{candidates: map[string]*model.CandidateDebug{}}
+}
+
+func (t *auctionTrace) candidate(item *model.LineItem) *model.CandidateDebug {
+	c, ok := t.candidates[item.ID]
+	if !ok {
+		c = &model.CandidateDebug{
+			LineItemID:   item.ID,
+			Name:         item.Name,
+			AdvertiserID: item.AdvertiserID,
+			Bid:          item.Bid,
+			Breakdown:    map[string]float64{},
+			Bucket:       -1,
+		}
+		t.candidates[item.ID] = c
+	}
+	return c
+}
+
+func (t *auctionTrace) addScore(item *model.LineItem, component string, weight float64) {
+	if t == nil {
+		return
+	}
+	t.candidate(item).Breakdown[component] += weight
+}
+
+func (t *auctionTrace) exclude(item *model.LineItem, reason string) {
+	if t == nil {
+		return
+	}
+	c := t.candidate(item)
+	c.Outcome, c.Reason = model.CandidateExcluded, reason
+}
+
+func (t *auctionTrace) bucket(item *model.LineItem, idx int, score float64) {
+	if t == nil {
+		return
+	}
+	c := t.candidate(item)
+	// everything ranked is a loser until it is picked as a winner
+	c.Bucket, c.Score, c.Outcome, c.Reason = idx, score, model.CandidateLost, reasonOutranked
+}
+
+func (t *auctionTrace) lose(item *model.LineItem, reason string) {
+	if t == nil {
+		return
+	}
+	c := t.candidate(item)
+	c.Outcome, c.Reason = model.CandidateLost, reason
+}
+
+func (t *auctionTrace) rank(item *model.LineItem, rank int) {
+	if t == nil {
+		return
+	}
+	t.candidate(item).Rank = rank
+}
+
+func (t *auctionTrace) win(item *model.LineItem, price float64) {
+	if t == nil {
+		return
+	}
+	c := t.candidate(item)
+	c.Outcome, c.Reason, c.Price = model.CandidateWon, "", price
+}
+
+// explored marks a winner that got its slot from the exploration policy, not from the ranking
+func (t *auctionTrace) explored(item *model.LineItem) {
+	if t == nil {
+		return
+	}
+	t.candidate(item).Reason = reasonExplored
+}
+
+// untouched marks every line item of the placement the auction never looked at, so the output covers all of them
+func (t *auctionTrace) untouched(items []*model.LineItem) {
+	if t == nil {
+		return
+	}
+	for _, item := range items {
+		if _, ok := t.candidates[item.ID]; !ok {
+			t.exclude(item, reasonNoMatch)
+		}
+	}
+}
+
+// list returns the candidates winners first (by rank), then losers by score, then the excluded ones
+func (t *auctionTrace) list() []*model.CandidateDebug {
+	outcomeOrder := map[string]int{model.CandidateWon: 0, model.CandidateLost: 1, model.CandidateExcluded: 2}
+	list := make([]*model.CandidateDebug, 0, len(t.candidates))
+	for _, c := range t.candidates {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		ca, cb := list[a], list[b]
+		if outcomeOrder[ca.Outcome] != outcomeOrder[cb.Outcome] {
+			return outcomeOrder[ca.Outcome] < outcomeOrder[cb.Outcome]
+		}
+		if (ca.Rank == 0) != (cb.Rank == 0) {
+			return ca.Rank != 0
+		}
+		if ca.Rank != cb.Rank {
+			return ca.Rank < cb.Rank
+		}
+		if ca.Score != cb.Score {
+			return ca.Score > cb.Score
+		}
+		return ca.LineItemID < cb.LineItemID
+	})
+	return list
+}
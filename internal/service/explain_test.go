@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+func TestExplain(t *testing.T) {
+	blocked := testLineItem("blocked", "adv-4", 3)
+	blocked.ExcludedKeywords = []string{"shoes"}
+	mobile := testLineItem("mobile", "adv-5", 3)
+	mobile.DeviceTypes = []string{model.DeviceTypeMobile}
+	hats := testLineItem("hats", "adv-6", 3)
+	hats.Keywords = []string{"hats"}
+	footer := testLineItem("footer", "adv-7", 3)
+	footer.Placement = "footer_banner"
+
+	cfg := testAdConfig(t)
+	cfg.Auction.PlacementFloors = map[string]float64{"homepage_top": 1}
+	s, _ := newTestAdService(t, cfg,
+		testLineItem("winner", "adv-1", 5),
+		testLineItem("capped", "adv-1", 4),
+		testLineItem("runner up", "adv-2", 2),
+		testLineItem("below floor", "adv-3", 0.5),
+		blocked, mobile, hats, footer,
+	)
+	ads, debug, err := s.Explain(model.WinningAdsQuery{Placement: "homepage_top", Keyword: "Shoes", MaxPerAdvertiser: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		if len(ads) != 1 || ads[0].Name != "winner" {
+			t.Fatalf("ads %v, want [winner]", adNames(ads))
+		}
+		if ads[0].AuctionID != "" || ads[0].ImpressionURL != "" || ads[0].ClickURL != "" {
+			t.Errorf("explained ad can be tracked: %+v", ads[0])
+		}
+		if debug.Floor != 1 || debug.DroppedByFloor != 1 || len(debug.Keywords) == 0 || debug.Keywords[0] != "shoe" {
+			t.Errorf("debug %+v, want floor 1, 1 dropped by floor and the normalized keyword", debug)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		wantOutcome string
+		wantReason  string
+	}{
+		{name: "winner", wantOutcome: model.CandidateWon},
+		{name: "capped", wantOutcome: model.CandidateLost, wantReason: reasonAdvertiserCap},
+		{name: "runner up", wantOutcome: model.CandidateLost, wantReason: reasonOutranked},
+		{name: "below floor", wantOutcome: model.CandidateExcluded, wantReason: reasonBelowFloor},
+		{name: "blocked", wantOutcome: model.CandidateExcluded, wantReason: reasonExcluded},
+		{name: "mobile", wantOutcome: model.CandidateExcluded, wantReason: reasonDeviceType},
+		{name: "hats", wantOutcome: model.CandidateExcluded, wantReason: reasonNoMatch},
+	}
+	candidates := map[string]*model.CandidateDebug{}
+	for _, c := range debug.Candidates {
+		candidates[c.Name] = c
+	}
+	if len(debug.Candidates) != len(tests) {
+		t.Errorf("%d candidates, want the %d line items of the placement", len(debug.Candidates), len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := candidates[tt.name]
+			if !ok {
+				t.Fatal("not explained")
+			}
+			if c.Outcome != tt.wantOutcome || c.Reason != tt.wantReason {
+				t.Errorf("outcome %s (%s), want %s (%s)", c.Outcome, c.Reason, tt.wantOutcome, tt.wantReason)
+			}
+			// candidates are listed winners first, then losers, then the excluded ones
+			if i < len(debug.Candidates) && debug.Candidates[i].Outcome != tt.wantOutcome {
+				t.Errorf("candidate %d is %s, want a %s one", i, debug.Candidates[i].Name, tt.wantOutcome)
+			}
+		})
+	}
+	if winner := candidates["winner"]; winner != nil && (winner.Rank != 1 || winner.Price != 5 || winner.Breakdown["keywordWeight"] == 0) {
+		t.Errorf("winner %+v, want rank 1, price 5 and the keyword weight in the breakdown", winner)
+	}
+}
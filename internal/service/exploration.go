@@ -0,0 +1,151 @@
+package service
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sweng-task/internal/model"
+)
+
+/*
+ Exploration gives new line items a chance to collect tracking data. Both the relevance and the eCPM ranking exploit what
+ is already known, a new line item with the prior CTR rarely makes it to the top and so never gets the impressions that
+ would prove it good. On a share of the requests the last winning slot is handed to an under-served candidate
+ (fewer impressions than APP_EXPLORATION_MIN_IMPRESSIONS on the placement) instead:
+   - epsilon_greedy picks one of them uniformly at random
+   - thompson samples a CTR from every candidate's Beta posterior and picks the best sampled bid × CTR, so items
+     looking promising after a few impressions get explored more often than hopeless ones
+ Every decision is logged and counted with the eCPM given up, that's what exploration costs.
+*/
+
+// Exploration policies
+const (
+	ExplorationNone          = "none"
+	ExplorationEpsilonGreedy = "epsilon_greedy"
+	ExplorationThompson      = "thompson"
+)
+
+var (
+	explorationTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_exploration_total",
+			Help: "Number of ad slots given to an under-served line item by the exploration policy.",
+		},
+		[]string{"placement", "policy"},
+	)
+
+	explorationCost = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_exploration_cost_ecpm_total",
+			Help: "Expected eCPM given up by exploration slots (displaced minus explored).",
+		},
+		[]string{"placement", "policy"},
+	)
+)
+
+// explore may replace the last winner in ranked with an under-served candidate, returns the explored line item id
+// or empty string. Candidates are the line items that passed all the filters, the explored one still has to respect
+// advertiser caps and competitive separation with the other winners
+func (s *AdService) explore(ranked []*model.LineItem, winners int, candidates map[string]float64, placement string, maxPerAdvertiser int, page *pageAuction, trace *auctionTrace) string {
+	policy := s.cfg.Exploration.Policy
+	if policy == ExplorationNone || winners == 0 || rand.Float64() >= s.cfg.Exploration.Share {
+		return ""
+	}
+	separation := newSeparation(maxPerAdvertiser)
+	if page != nil {
+		separation = page.separation.clone()
+	}
+	inRanking := make(map[string]bool, len(ranked))
+	for i, item := range ranked {
+		inRanking[item.ID] = true
+		if i < winners-1 {
+			separation.add(item.AdvertiserID, s.runTimeDB.CompetitiveCategories[item.ID])
+		}
+	}
+	pool := []*model.LineItem{}
+	for id := range candidates {
+		item := s.lis.items[id]
+		if inRanking[id] || page.hasServed(id) || separation.check(item.AdvertiserID, s.runTimeDB.CompetitiveCategories[id]) != "" {
+			continue
+		}
+		if impressions, _ := s.ctr.Stats(id, placement); impressions < s.cfg.Exploration.MinImpressions {
+			pool = append(pool, item)
+		}
+	}
+	if len(pool) == 0 {
+		return ""
+	}
+
+	var explored *model.LineItem
+	switch policy {
+	case ExplorationEpsilonGreedy:
+		explored = pool[rand.IntN(len(pool))]
+	case ExplorationThompson:
+		best := -1.0
+		for _, item := range pool {
+			impressions, clicks := s.ctr.Stats(item.ID, placement)
+			clicks = min(clicks, impressions)
+			sampled := item.Bid * sampleBeta(clicks+s.ctr.alpha, impressions-clicks+s.ctr.beta)
+			if sampled > best {
+				best, explored = sampled, item
+			}
+		}
+	}
+
+	displaced := ranked[winners-1]
+	ranked[winners-1] = explored
+	trace.lose(displaced, reasonExplorationSlot)
+	trace.rank(explored, winners)
+
+	cost := s.expectedECPM(displaced, placement) - s.expectedECPM(explored, placement)
+	explorationTotal.WithLabelValues(placement, policy).Inc()
+	explorationCost.WithLabelValues(placement, policy).Add(math.Max(cost, 0))
+	s.logs.Infow("Exploration slot",
+		"placement", placement,
+		"policy", policy,
+		"slot", winners,
+		"explored_line_item_id", explored.ID,
+		"displaced_line_item_id", displaced.ID,
+		"explored_ecpm", s.expectedECPM(explored, placement),
+		"displaced_ecpm", s.expectedECPM(displaced, placement),
+		"cost_ecpm", cost,
+	)
+	return explored.ID
+}
+
+func (s *AdService) expectedECPM(item *model.LineItem, placement string) float64 {
+	return item.Bid * s.ctr.Predict(item.ID, placement) * 1000
+}
+
+// sampleBeta draws from Beta(a, b) as X/(X+Y) with X ~ Gamma(a), Y ~ Gamma(b)
+func sampleBeta(a, b float64) float64 {
+	x := sampleGamma(a)
+	y := sampleGamma(b)
+	if x+y == 0 {
+		return 0
+	}
+	return x / (x + y)
+}
+
+// sampleGamma draws from Gamma(shape, 1) with Marsaglia and Tsang's method, shape < 1 is boosted by U^(1/shape)
+func sampleGamma(shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(shape+1) * math.Pow(rand.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+func TestExplore(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           string
+		share            float64
+		minImpressions   float64
+		items            []model.LineItemCreate
+		limit            int
+		maxPerAdvertiser int
+		want             []string
+		wantExplored     string
+	}{
+		{
+			name:   "epsilon greedy",
+			policy: ExplorationEpsilonGreedy, share: 1, minImpressions: 1000,
+			items: []model.LineItemCreate{testLineItem("w", "adv-1", 5), testLineItem("r", "adv-2", 4), testLineItem("n", "adv-3", 3)},
+			limit: 1, want: []string{"n"}, wantExplored: "n",
+		},
+		{
+			name:   "thompson",
+			policy: ExplorationThompson, share: 1, minImpressions: 1000,
+			items: []model.LineItemCreate{testLineItem("w", "adv-1", 5), testLineItem("r", "adv-2", 4), testLineItem("n", "adv-3", 3)},
+			limit: 1, want: []string{"n"}, wantExplored: "n",
+		},
+		{
+			name:   "last slot only",
+			policy: ExplorationEpsilonGreedy, share: 1, minImpressions: 1000,
+			items: []model.LineItemCreate{testLineItem("w1", "adv-1", 5), testLineItem("w2", "adv-2", 4), testLineItem("r", "adv-3", 3), testLineItem("n", "adv-4", 2)},
+			limit: 2, want: []string{"w1", "n"}, wantExplored: "n",
+		},
+		{
+			name:   "explored line item respects the advertiser cap",
+			policy: ExplorationEpsilonGreedy, share: 1, minImpressions: 1000,
+			items: []model.LineItemCreate{
+				testLineItem("w1", "adv-1", 5), testLineItem("w2", "adv-2", 4), testLineItem("r", "adv-3", 3),
+				testLineItem("capped", "adv-1", 2), testLineItem("n", "adv-4", 1),
+			},
+			limit: 2, maxPerAdvertiser: 1, want: []string{"w1", "n"}, wantExplored: "n",
+		},
+		{
+			name:   "policy none",
+			policy: ExplorationNone, share: 1, minImpressions: 1000,
+			items: []model.LineItemCreate{testLineItem("w", "adv-1", 5), testLineItem("r", "adv-2", 4), testLineItem("n", "adv-3", 3)},
+			limit: 1, want: []string{"w"},
+		},
+		{
+			name:   "share 0",
+			policy: ExplorationEpsilonGreedy, share: 0, minImpressions: 1000,
+			items: []model.LineItemCreate{testLineItem("w", "adv-1", 5), testLineItem("r", "adv-2", 4), testLineItem("n", "adv-3", 3)},
+			limit: 1, want: []string{"w"},
+		},
+		{
+			name:   "nobody under-served",
+			policy: ExplorationEpsilonGreedy, share: 1, minImpressions: 0,
+			items: []model.LineItemCreate{testLineItem("w", "adv-1", 5), testLineItem("r", "adv-2", 4), testLineItem("n", "adv-3", 3)},
+			limit: 1, want: []string{"w"},
+		},
+		{
+			name:   "runner up is not explored",
+			policy: ExplorationEpsilonGreedy, share: 1, minImpressions: 1000,
+			items: []model.LineItemCreate{testLineItem("w", "adv-1", 5), testLineItem("r", "adv-2", 4)},
+			limit: 1, want: []string{"w"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testAdConfig(t)
+			cfg.Exploration.Policy, cfg.Exploration.Share, cfg.Exploration.MinImpressions = tt.policy, tt.share, tt.minImpressions
+			s, _ := newTestAdService(t, cfg, tt.items...)
+			for range 20 {
+				ads, _ := s.GetAd(model.WinningAdsQuery{Placement: "homepage_top", Keyword: "shoes", Limit: tt.limit, MaxPerAdvertiser: tt.maxPerAdvertiser})
+				if got := adNames(ads); !slices.Equal(got, tt.want) {
+					t.Fatalf("ads %v, want %v", got, tt.want)
+				}
+				for _, ad := range ads {
+					if ad.Explored != (ad.Name == tt.wantExplored) {
+						t.Errorf("%s explored %v, want %v", ad.Name, ad.Explored, ad.Name == tt.wantExplored)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSampleBeta(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b float64
+	}{
+		{name: "uniform", a: 1, b: 1},
+		{name: "prior", a: 1, b: 99},
+		{name: "after clicks", a: 21, b: 181},
+		{name: "shape under 1", a: 0.5, b: 0.5},
+	}
+	const samples = 50000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum, sumSquares := 0.0, 0.0
+			for range samples {
+				x := sampleBeta(tt.a, tt.b)
+				if x < 0 || x > 1 {
+					t.Fatalf("sample %g out of [0, 1]", x)
+				}
+				sum += x
+				sumSquares += x * x
+			}
+			mean := sum / samples
+			variance := sumSquares/samples - mean*mean
+			wantMean := tt.a / (tt.a + tt.b)
+			wantVariance := tt.a * tt.b / ((tt.a + tt.b) * (tt.a + tt.b) * (tt.a + tt.b + 1))
+			if math.Abs(mean-wantMean) > 0.01 {
+				t.Errorf("mean %.4f, want %.4f", mean, wantMean)
+			}
+			if math.Abs(variance-wantVariance) > 0.1*wantVariance {
+				t.Errorf("variance %.6f, want %.6f", variance, wantVariance)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"encoding/base64"
+	"os"
+	"sync"
+
+	"sweng-task/internal/config"
+
+	"go.uber.org/zap"
+)
+
+// FilePublisher appends the messages to a newline-delimited file (APP_PUB_SUB_FILE), for local runs without kafka.
+// `tail -f` it to see the tracking events come in. Protobuf messages are written base64 encoded, one per line
+type FilePublisher struct {
+	log  *zap.SugaredLogger
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFilePublisher(log *zap.SugaredLogger, cfg *config.Config) (*FilePublisher, error) {
+	file, err := os.OpenFile(cfg.PubSub.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	log.Infow("Tracking events are written to a file", "file", cfg.PubSub.File)
+	return &FilePublisher{
+		log:  log,
+		file: file,
+	}, nil
+}
+
+func (f *FilePublisher) Publish(msg Message) error {
+	line := string(msg.Value)
+	if msg.Headers[HeaderContentType] != ContentTypeJSON {
+		line = base64.StdEncoding.EncodeToString(msg.Value)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.file.WriteString(line + "\n")
+	if err != nil {
+		f.log.Warnw("Failed to write tracking event", "error", err)
+	}
+	return err
+}
+
+func (f *FilePublisher) PublishBatch(msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = f.Publish(msg)
+	}
+	return errs
+}
+
+func (f *FilePublisher) Accepting() bool {
+	return true
+}
+
+func (f *FilePublisher) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.file.Close(); err != nil {
+		f.log.Warnw("Failed to close tracking event file", "error", err)
+	}
+}
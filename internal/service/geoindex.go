@@ -0,0 +1,106 @@
+package service
+
+import (
+	"math"
+
+	"sweng-task/internal/model"
+)
+
+/*
+ GeoIndex is a fixed grid spatial index for point-radius targeting. Every area is stored in all the grid cells its
+ bounding box touches, so a lookup only has to check the areas of a single cell instead of every geo targeted line item.
+ With 1 degree cells (~111km) and radius capped at 500km an area lands in at most ~100 cells, lookups stay O(areas in cell).
+*/
+
+const earthRadiusKm = 6371.0
+
+type geoCell struct {
+	lat int
+	lon int
+}
+
+type geoEntry struct {
+	lineItemId string
+	area       model.GeoArea
+}
+
+type GeoIndex struct {
+	cellSize float64
+	cells    map[geoCell][]geoEntry
+}
+
+func NewGeoIndex(cellSize float64) *GeoIndex {
+	return &GeoIndex{
+		cellSize: cellSize,
+		cells:    map[geoCell][]geoEntry{},
+	}
+}
+
+func (g *GeoIndex) Insert(lineItemId string, area model.GeoArea) {
+	entry := geoEntry{lineItemId: lineItemId, area: area}
+	latDelta := area.RadiusKm / 111.32
+	minLat := g.latIndex(math.Max(area.Lat-latDelta, -90))
+	maxLat := g.latIndex(math.Min(area.Lat+latDelta, 90))
+
+	lonCells := g.lonCellCount()
+	minLon, maxLon := 0, lonCells-1
+	// a degree of longitude is shortest at the pole-side edge of the area, that's where it spans the most degrees.
+	// Near the poles it's almost nothing, just cover the whole ring
+	edgeLat := math.Min(math.Abs(area.Lat)+latDelta, 90)
+	if cos := math.Cos(edgeLat * math.Pi / 180); cos > 0.01 {
+		lonDelta := area.RadiusKm / (111.32 * cos)
+		if lonDelta < 180 {
+			minLon = g.lonIndex(area.Lon - lonDelta)
+			maxLon = g.lonIndex(area.Lon + lonDelta)
+			// area crosses the antimeridian, wrap the range
+			if maxLon < minLon {
+				maxLon += lonCells
+			}
+		}
+	}
+
+	for lat := minLat; lat <= maxLat; lat++ {
+		for lon := minLon; lon <= maxLon; lon++ {
+			cell := geoCell{lat: lat, lon: lon % lonCells}
+			g.cells[cell] = append(g.cells[cell], entry)
+		}
+	}
+}
+
+// Query returns every line item with an area containing the point, without duplicates
+func (g *GeoIndex) Query(lat float64, lon float64) map[string]bool {
+	matches := map[string]bool{}
+	cell := geoCell{lat: g.latIndex(lat), lon: g.lonIndex(lon)}
+	for _, entry := range g.cells[cell] {
+		if matches[entry.lineItemId] {
+			continue
+		}
+		if haversineKm(lat, lon, entry.area.Lat, entry.area.Lon) <= entry.area.RadiusKm {
+			matches[entry.lineItemId] = true
+		}
+	}
+	return matches
+}
+
+func (g *GeoIndex) latIndex(lat float64) int {
+	return int(math.Floor((lat + 90) / g.cellSize))
+}
+
+func (g *GeoIndex) lonIndex(lon float64) int {
+	// normalize into [-180, 180) first, so wrapped longitudes land in the right cell
+	lon = math.Mod(math.Mod(lon+180, 360)+360, 360) - 180
+	return int(math.Floor((lon+180)/g.cellSize)) % g.lonCellCount()
+}
+
+func (g *GeoIndex) lonCellCount() int {
+	return int(math.Ceil(360 / g.cellSize))
+}
+
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
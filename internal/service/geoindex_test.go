@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+// every point of a grid around the area has to match exactly when it's within the radius
+func TestGeoIndexQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		area model.GeoArea
+	}{
+		{name: "equator", area: model.GeoArea{Lat: 0, Lon: 10, RadiusKm: 300}},
+		{name: "mid latitude", area: model.GeoArea{Lat: 48.85, Lon: 2.35, RadiusKm: 50}},
+		{name: "high latitude large radius", area: model.GeoArea{Lat: 60, Lon: 0, RadiusKm: 500}},
+		{name: "southern high latitude", area: model.GeoArea{Lat: -65, Lon: 100, RadiusKm: 500}},
+		{name: "near the pole", area: model.GeoArea{Lat: 87, Lon: 0, RadiusKm: 400}},
+		{name: "antimeridian", area: model.GeoArea{Lat: 70, Lon: 179.5, RadiusKm: 300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := NewGeoIndex(1)
+			index.Insert("li-1", tt.area)
+			missed, extra := 0, 0
+			for lat := tt.area.Lat - 10; lat <= tt.area.Lat+10; lat += 0.1 {
+				if lat < -90 || lat > 90 {
+					continue
+				}
+				for lon := tt.area.Lon - 40; lon <= tt.area.Lon+40; lon += 0.2 {
+					want := haversineKm(lat, lon, tt.area.Lat, tt.area.Lon) <= tt.area.RadiusKm
+					got := index.Query(lat, lon)["li-1"]
+					if want && !got {
+						missed++
+					}
+					if got && !want {
+						extra++
+					}
+				}
+			}
+			if missed > 0 || extra > 0 {
+				t.Errorf("%d points within the radius missed, %d outside matched", missed, extra)
+			}
+		})
+	}
+}
+
+func TestHaversineKm(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{name: "same point", lat1: 52.52, lon1: 13.40, lat2: 52.52, lon2: 13.40, want: 0},
+		{name: "paris to london", lat1: 48.8566, lon1: 2.3522, lat2: 51.5074, lon2: -0.1278, want: 343.5},
+		{name: "one degree on the equator", lat1: 0, lon1: 0, lat2: 0, lon2: 1, want: 111.2},
+		{name: "across the antimeridian", lat1: 0, lon1: 179.5, lat2: 0, lon2: -179.5, want: 111.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := haversineKm(tt.lat1, tt.lon1, tt.lat2, tt.lon2); got < tt.want-1 || got > tt.want+1 {
+				t.Errorf("haversineKm = %.1f, want %.1f", got, tt.want)
+			}
+		})
+	}
+}
@@ -39,17 +39,27 @@ func (s *LineItemService) Create(item model.LineItemCreate) (*model.LineItem, er
 	now := time.Now()
 
 	lineItem := &model.LineItem{
-		ID:           "li_" + uuid.New().String(),
-		Name:         item.Name,
-		AdvertiserID: item.AdvertiserID,
-		Bid:          item.Bid,
-		Budget:       item.Budget,
-		Placement:    item.Placement,
-		Categories:   item.Categories,
-		Keywords:     item.Keywords,
-		Status:       model.LineItemStatusActive,
-		CreatedAt:    now,
-		UpdatedAt:    now,
+		ID:                    "li_" + uuid.New().String(),
+		Name:                  item.Name,
+		AdvertiserID:          item.AdvertiserID,
+		Bid:                   item.Bid,
+		Budget:                item.Budget,
+		Placement:             item.Placement,
+		Categories:            item.Categories,
+		Keywords:              item.Keywords,
+		ExcludedKeywords:      item.ExcludedKeywords,
+		ExcludedCategories:    item.ExcludedCategories,
+		PrefixMatch:           item.PrefixMatch,
+		FuzzyMatch:            item.FuzzyMatch,
+		Geo:                   item.Geo,
+		DeviceTypes:           item.DeviceTypes,
+		OperatingSystems:      item.OperatingSystems,
+		Browsers:              item.Browsers,
+		CompetitiveCategories: item.CompetitiveCategories,
+		Creative:              item.Creative,
+		Status:                model.LineItemStatusActive,
+		CreatedAt:             now,
+		UpdatedAt:             now,
 	}
 
 	s.items[lineItem.ID] = lineItem
@@ -98,6 +108,34 @@ func (s *LineItemService) GetAll(advertiserID, placement string) ([]*model.LineI
 	return result, nil
 }
 
+// AddSpend books the cost of a served impression (clearing price is CPM, so one impression costs price/1000)
+func (s *LineItemService) AddSpend(id string, amount float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.items[id]
+	if !exists {
+		return ErrLineItemNotFound
+	}
+	item.Spend += amount
+	return nil
+}
+
+// LandingPage returns where a click on the line item goes, the creative click through URL or the served content
+func (s *LineItemService) LandingPage(id string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.items[id]
+	if !exists {
+		return "", ErrLineItemNotFound
+	}
+	if item.Creative != nil && item.Creative.ClickThroughURL != "" {
+		return item.Creative.ClickThroughURL, nil
+	}
+	return serveURL(id), nil
+}
+
 func (s *LineItemService) Update(item model.LineItem) (*model.LineItem, error) {
 	// We can use this method to re-create the above maps and hotswap it with a write lock
 	// This
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// MemoryPublisher keeps the published messages in memory, for tests
+type MemoryPublisher struct {
+	log      *zap.SugaredLogger
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryPublisher(log *zap.SugaredLogger) *MemoryPublisher {
+	return &MemoryPublisher{
+		log: log,
+	}
+}
+
+func (m *MemoryPublisher) Publish(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *MemoryPublisher) PublishBatch(msgs []Message) []error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msgs...)
+	return make([]error, len(msgs))
+}
+
+func (m *MemoryPublisher) Accepting() bool {
+	return true
+}
+
+func (m *MemoryPublisher) Close() {}
+
+// Messages returns a copy of everything published so far, oldest first
+func (m *MemoryPublisher) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset forgets the published messages
+func (m *MemoryPublisher) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+ Normalizer makes "Discount", "discounts" and "discount " the same term. Same pipeline has to run on both sides,
+ while indexing (Cache.PopulateCache) and while querying (AdService.GetAd), otherwise the exact map lookup in RunTimeDB misses.
+ Synonyms are expanded only on the query side, so operators can change the dictionary without re-indexing.
+*/
+
+type Normalizer struct {
+	log      *zap.SugaredLogger
+	mu       sync.RWMutex
+	synonyms map[string][]string
+}
+
+func NewNormalizer(log *zap.SugaredLogger) *Normalizer {
+	return &Normalizer{
+		log:      log,
+		synonyms: map[string][]string{},
+	}
+}
+
+// Normalize runs unicode normalization, case folding, whitespace trimming and light stemming on a single term
+func (n *Normalizer) Normalize(term string) string {
+	term = norm.NFKC.String(term)
+	// cases.Caser keeps state, so it can't be shared between goroutines
+	term = cases.Fold().String(term)
+	term = strings.Join(strings.Fields(term), " ")
+	return stem(term)
+}
+
+// NormalizeAll normalizes a list of terms and drops the duplicates it produces ("deal", "Deals" -> "deal")
+func (n *Normalizer) NormalizeAll(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	result := make([]string, 0, len(terms))
+	for _, term := range terms {
+		normalized := n.Normalize(term)
+		if normalized == "" || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		result = append(result, normalized)
+	}
+	return result
+}
+
+// Expand returns the normalized term together with all of its synonyms, ready to be looked up in RunTimeDB
+func (n *Normalizer) Expand(term string) []string {
+	normalized := n.Normalize(term)
+	if normalized == "" {
+		return []string{}
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]string{normalized}, n.synonyms[normalized]...)
+}
+
+// SetSynonyms replaces the synonyms of a term, relation is symmetric so "deal" ≈ "bargain" also means "bargain" ≈ "deal"
+func (n *Normalizer) SetSynonyms(term string, synonyms []string) []string {
+	normalized := n.Normalize(term)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.unlink(normalized)
+	for _, synonym := range n.NormalizeAll(synonyms) {
+		if synonym == normalized {
+			continue
+		}
+		n.synonyms[normalized] = appendUnique(n.synonyms[normalized], synonym)
+		n.synonyms[synonym] = appendUnique(n.synonyms[synonym], normalized)
+	}
+	n.log.Infow("Synonyms updated", "term", normalized, "synonyms", n.synonyms[normalized])
+	return append([]string{}, n.synonyms[normalized]...)
+}
+
+// RemoveSynonyms drops a term from the dictionary, returns false if it was never there
+func (n *Normalizer) RemoveSynonyms(term string) bool {
+	normalized := n.Normalize(term)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.synonyms[normalized]; !ok {
+		return false
+	}
+	n.unlink(normalized)
+	return true
+}
+
+// GetSynonyms returns a copy of the whole dictionary
+func (n *Normalizer) GetSynonyms() map[string][]string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	dictionary := make(map[string][]string, len(n.synonyms))
+	for term, synonyms := range n.synonyms {
+		dictionary[term] = append([]string{}, synonyms...)
+		sort.Strings(dictionary[term])
+	}
+	return dictionary
+}
+
+// unlink removes every link of the term in both directions, caller must hold the write lock
+func (n *Normalizer) unlink(term string) {
+	for _, synonym := range n.synonyms[term] {
+		remaining := removeValue(n.synonyms[synonym], term)
+		if len(remaining) == 0 {
+			delete(n.synonyms, synonym)
+		} else {
+			n.synonyms[synonym] = remaining
+		}
+	}
+	delete(n.synonyms, term)
+}
+
+// stem is a light English (Harman "S") stemmer, it only folds plurals. Anything more aggressive like porter
+// starts merging unrelated ad keywords ("gaming" and "game") which hurts more than it helps here
+func stem(term string) string {
+	if len(term) <= 3 || strings.Contains(term, " ") {
+		return term
+	}
+	switch {
+	case strings.HasSuffix(term, "ies") && !strings.HasSuffix(term, "eies") && !strings.HasSuffix(term, "aies"):
+		return term[:len(term)-3] + "y"
+	case strings.HasSuffix(term, "es") && !strings.HasSuffix(term, "aes") && !strings.HasSuffix(term, "ees") && !strings.HasSuffix(term, "oes"):
+		return term[:len(term)-1]
+	case strings.HasSuffix(term, "s") && !strings.HasSuffix(term, "us") && !strings.HasSuffix(term, "ss"):
+		return term[:len(term)-1]
+	}
+	return term
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func removeValue(values []string, value string) []string {
+	result := values[:0]
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		term string
+		want string
+	}{
+		{term: "discount", want: "discount"},
+		{term: "Discounts ", want: "discount"},
+		{term: "  DEALS", want: "deal"},
+		{term: "Ｄｉｓｃｏｕｎｔｓ", want: "discount"},
+		{term: "batteries", want: "battery"},
+		{term: "boxes", want: "boxe"},
+		{term: "shoes", want: "shoe"},
+		{term: "toes", want: "toe"},
+		{term: "status", want: "status"},
+		{term: "glass", want: "glass"},
+		{term: "bus", want: "bus"},
+		{term: "Straße", want: "strasse"},
+		{term: "New   York  Shoes", want: "new york shoes"},
+		{term: "   ", want: ""},
+	}
+	n := NewNormalizer(zap.NewNop().Sugar())
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			if got := n.Normalize(tt.term); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.term, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeAll(t *testing.T) {
+	n := NewNormalizer(zap.NewNop().Sugar())
+	got := n.NormalizeAll([]string{"Deals", "deal", " ", "Bargains", "DEAL "})
+	if want := []string{"deal", "bargain"}; !slices.Equal(got, want) {
+		t.Errorf("NormalizeAll = %v, want %v", got, want)
+	}
+}
+
+func TestSynonyms(t *testing.T) {
+	n := NewNormalizer(zap.NewNop().Sugar())
+	n.SetSynonyms("Deals", []string{"bargains", "offer", "deal"})
+	n.SetSynonyms("sale", []string{"offer"})
+
+	tests := []struct {
+		name string
+		term string
+		want []string
+	}{
+		{name: "term", term: "deal", want: []string{"deal", "bargain", "offer"}},
+		{name: "symmetric", term: "Bargains", want: []string{"bargain", "deal"}},
+		{name: "linked twice", term: "offers", want: []string{"offer", "deal", "sale"}},
+		{name: "no synonyms", term: "shoes", want: []string{"shoe"}},
+		{name: "empty", term: " ", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := n.Expand(tt.term); !slices.Equal(got, tt.want) {
+				t.Errorf("Expand(%q) = %v, want %v", tt.term, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		n.SetSynonyms("deal", []string{"discount"})
+		if got, want := n.Expand("bargain"), []string{"bargain"}; !slices.Equal(got, want) {
+			t.Errorf("old synonym still linked: Expand = %v, want %v", got, want)
+		}
+		if got, want := n.Expand("offer"), []string{"offer", "sale"}; !slices.Equal(got, want) {
+			t.Errorf("Expand(offer) = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if !n.RemoveSynonyms("Deals") {
+			t.Fatal("RemoveSynonyms(Deals) = false")
+		}
+		if n.RemoveSynonyms("deal") {
+			t.Error("removed twice")
+		}
+		if _, ok := n.GetSynonyms()["discount"]; ok {
+			t.Error("discount still linked to the removed term")
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"slices"
+	"strings"
+
+	"sweng-task/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/text/language"
+)
+
+/*
+ OpenRTB bidding maps the exchange request onto the same auction GetAd runs. Every imp is an ad slot (tagid is the
+ placement) and the imps of one request share a pageAuction like the batch endpoint, so the exchange never gets the
+ same line item twice in one response. Site keywords and categories are the targeting, device and user give geo,
+ device and the experiment bucket. Banner imps get an iframe markup, video imps a VAST document. The bidder only trades in USD.
+ Both carry the signed tracking of the ad: the banner has the impression pixel and a transparent link over the iframe going
+ through the click redirect, the VAST document its signed impression and event URLs.
+*/
+
+const openRTBCurrency = "USD"
+
+var openRTBWins = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "openrtb_win_notices_total",
+		Help: "Number of OpenRTB win notices received from exchanges.",
+	},
+	[]string{"placement"},
+)
+
+// OpenRTB device types (list 5.21) mapped to the device types line items target
+var openRTBDeviceTypes = map[int]string{
+	1: model.DeviceTypeMobile, // mobile/tablet
+	2: model.DeviceTypeDesktop,
+	3: model.DeviceTypeCTV,
+	4: model.DeviceTypeMobile, // phone
+	5: model.DeviceTypeTablet,
+	7: model.DeviceTypeCTV, // set top box
+}
+
+// BidOpenRTB returns the bids of the request, nil when nothing bids (no-bid is answered with 204)
+func (s *AdService) BidOpenRTB(request model.BidRequest) *model.BidResponse {
+	if len(request.Cur) > 0 && !slices.Contains(request.Cur, openRTBCurrency) {
+		return nil
+	}
+	base := openRTBQuery(request)
+	page := newPageAuction(s.cfg.Auction.MaxAdsPerAdvertiser)
+	bids := []model.Bid{}
+	for _, imp := range request.Imp {
+		if imp.BidFloorCur != "" && !strings.EqualFold(imp.BidFloorCur, openRTBCurrency) {
+			continue
+		}
+		if imp.Banner == nil && imp.Video == nil {
+			continue
+		}
+		query := base
+		query.Placement = imp.TagID
+		query.Limit = 1
+		query.BidFloor = imp.BidFloor
+		// video imps can only be filled by line items with a video creative, markup is VAST
+		query.Video = imp.Banner == nil
+		ads, _ := s.auction(query, page, nil)
+		if len(ads) == 0 {
+			continue
+		}
+		ad := ads[0]
+		// one ad per imp, the bid id is the auction id so the win notice can be joined with the tracking events
+		bid := model.Bid{
+			ID:    ad.AuctionID,
+			ImpID: imp.ID,
+			Price: ad.Price,
+			CID:   ad.ID,
+			CrID:  ad.ID,
+		}
+		bid.NURL = s.winNoticeURL(bid.ID, imp, ad)
+		if query.Video {
+			markup, err := MarshalVAST(s.renderVAST(ads, query.UserID))
+			if err != nil {
+				s.logs.Errorw("Failed to render VAST", "line_item_id", ad.ID, "error", err)
+				continue
+			}
+			bid.AdM = string(markup)
+			bid.W, bid.H = imp.Video.W, imp.Video.H
+		} else {
+			bid.W, bid.H = bannerSize(imp.Banner)
+			bid.AdM = bannerMarkup(ad, bid.W, bid.H)
+		}
+		bids = append(bids, bid)
+	}
+	s.logs.Debugw("OpenRTB request", "id", request.ID, "imps", len(request.Imp), "bids", len(bids))
+	if len(bids) == 0 {
+		return nil
+	}
+	return &model.BidResponse{
+		ID:      request.ID,
+		SeatBid: []model.SeatBid{{Bid: bids}},
+		BidID:   uuid.New().String(),
+		Cur:     openRTBCurrency,
+	}
+}
+
+// openRTBQuery builds the targeting shared by all imps of the request
+func openRTBQuery(request model.BidRequest) model.WinningAdsQuery {
+	query := model.WinningAdsQuery{}
+	var geo *model.Geo
+	if site := request.Site; site != nil {
+		query.Keywords = append(splitKeywords(site.Keywords), site.KwArray...)
+		query.Categories = site.Cat
+	}
+	if user := request.User; user != nil {
+		query.UserID = user.ID
+		query.Keywords = append(query.Keywords, splitKeywords(user.Keywords)...)
+		query.Keywords = append(query.Keywords, user.KwArray...)
+		geo = user.Geo
+	}
+	if device := request.Device; device != nil {
+		query.Device = ParseUserAgent(device.UA)
+		// the exchange knows the device type better than a user agent guess
+		if deviceType, ok := openRTBDeviceTypes[device.DeviceType]; ok {
+			query.Device.Type = deviceType
+		}
+		if device.Geo != nil {
+			geo = device.Geo
+		}
+	}
+	if geo != nil {
+		query.Country = openRTBCountry(geo.Country)
+		query.Region = strings.ToUpper(geo.Region)
+		if query.Region != "" && !strings.Contains(query.Region, "-") && query.Country != "" {
+			query.Region = query.Country + "-" + query.Region
+		}
+		query.Lat, query.Lon = geo.Lat, geo.Lon
+	}
+	return query
+}
+
+// openRTBCountry turns the alpha-3 country of OpenRTB into the alpha-2 code line items target, unknown codes are dropped
+func openRTBCountry(code string) string {
+	if code == "" {
+		return ""
+	}
+	region, err := language.ParseRegion(code)
+	if err != nil || !region.IsCountry() {
+		return ""
+	}
+	return region.String()
+}
+
+func splitKeywords(keywords string) []string {
+	result := []string{}
+	for _, keyword := range strings.Split(keywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			result = append(result, keyword)
+		}
+	}
+	return result
+}
+
+func bannerSize(banner *model.Banner) (int, int) {
+	if banner.W > 0 && banner.H > 0 {
+		return banner.W, banner.H
+	}
+	if len(banner.Format) > 0 {
+		return banner.Format[0].W, banner.Format[0].H
+	}
+	return 0, 0
+}
+
+// bannerMarkup is the creative iframe with the signed impression pixel, clicks land on the link laid over the iframe
+// so they go through the click redirect whatever the creative does
+func bannerMarkup(ad *model.Ad, w int, h int) string {
+	return fmt.Sprintf(`<div style="position:relative;width:%dpx;height:%dpx">`+
+		`<iframe src="%s" width="%d" height="%d" title="%s" frameborder="0" marginwidth="0" marginheight="0" scrolling="no"></iframe>`+
+		`<a href="%s" target="_blank" rel="noopener" style="position:absolute;top:0;left:0;width:100%%;height:100%%"></a>`+
+		`<img src="%s" width="1" height="1" alt="" style="position:absolute;border:0">`+
+		`</div>`,
+		w, h, html.EscapeString(ad.ServeURL), w, h, html.EscapeString(ad.Name), html.EscapeString(ad.ClickURL), html.EscapeString(ad.ImpressionURL))
+}
+
+// RecordWinNotice counts a won OpenRTB auction, the ids come from the verified nurl token and price is the clearing
+// price the exchange filled into the nurl
+func (s *AdService) RecordWinNotice(bidID string, lineItemID string, placement string, price float64) {
+	openRTBWins.WithLabelValues(placement).Inc()
+	s.logs.Infow("OpenRTB win notice", "bid_id", bidID, "line_item_id", lineItemID, "placement", placement, "price", price)
+}
+
+// winNoticeURL points to the win notice endpoint, ${AUCTION_PRICE} is replaced by the exchange with the clearing price.
+// The bid, line item and placement are signed like the tracking URLs, so nobody can count wins for a line item by hand
+func (s *AdService) winNoticeURL(bidID string, imp model.Imp, ad *model.Ad) string {
+	values := url.Values{}
+	values.Set("imp_id", imp.ID)
+	values.Set("t", s.signer.Sign(model.TrackingToken{
+		AuctionID:  bidID,
+		LineItemID: ad.ID,
+		Placement:  ad.Placement,
+		Price:      ad.Price,
+		EventType:  model.TokenTypeWinNotice,
+	}))
+	return fmt.Sprintf("%s/openrtb2/win?%s&price=${AUCTION_PRICE}", strings.TrimRight(s.cfg.Server.PublicURL, "/"), values.Encode())
+}
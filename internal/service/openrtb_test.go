@@ -0,0 +1,147 @@
+package service
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+// auction ids, response ids and tokens change on every call, the golden responses have placeholders instead
+var (
+	openRTBUUIDPattern  = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	openRTBTokenPattern = regexp.MustCompile(`t=[A-Za-z0-9_%.-]+`)
+)
+
+func TestBidOpenRTB(t *testing.T) {
+	banner := testLineItem("Shoes", "adv-1", 2)
+	video := testLineItem("Shoes video", "adv-2", 3)
+	video.Placement = "video_preroll"
+	video.Creative = &model.Creative{
+		MediaFiles: []model.MediaFile{{URL: "https://cdn.example/shoes.mp4", MimeType: "video/mp4", Width: 640, Height: 360}},
+		Duration:   15,
+	}
+	cfg := testAdConfig(t)
+	cfg.Server.PublicURL = "https://ads.example"
+	s, items := newTestAdService(t, cfg, banner, video)
+	ids := strings.NewReplacer(items[0].ID, "li-banner", items[1].ID, "li-video")
+
+	tests := []struct {
+		name    string
+		request string
+		// golden response as exchanges get it (encoding/json escapes &, < and >), nothing means no bid
+		want string
+	}{
+		{
+			name:    "banner",
+			request: `{"id":"req-1","imp":[{"id":"1","tagid":"homepage_top","banner":{"format":[{"w":300,"h":250}]}}],"site":{"keywords":"shoes, running"}}`,
+			want: `{"id":"req-1","seatbid":[{"bid":[{"id":"UUID","impid":"1","price":2,` +
+				`"nurl":"https://ads.example/openrtb2/win?imp_id=1\u0026t=TOKEN\u0026price=${AUCTION_PRICE}",` +
+				`"adm":"\u003cdiv style=\"position:relative;width:300px;height:250px\"\u003e` +
+				`\u003ciframe src=\"https://content.realtimemediatool.com/data/li-banner\" width=\"300\" height=\"250\" title=\"Shoes\" frameborder=\"0\" marginwidth=\"0\" marginheight=\"0\" scrolling=\"no\"\u003e\u003c/iframe\u003e` +
+				`\u003ca href=\"https://ads.example/t/click?t=TOKEN\" target=\"_blank\" rel=\"noopener\" style=\"position:absolute;top:0;left:0;width:100%;height:100%\"\u003e\u003c/a\u003e` +
+				`\u003cimg src=\"https://ads.example/t/imp.gif?t=TOKEN\" width=\"1\" height=\"1\" alt=\"\" style=\"position:absolute;border:0\"\u003e\u003c/div\u003e",` +
+				`"cid":"li-banner","crid":"li-banner","w":300,"h":250}]}],"bidid":"UUID","cur":"USD"}`,
+		},
+		{
+			name:    "video",
+			request: `{"id":"req-2","imp":[{"id":"v","tagid":"video_preroll","video":{"w":640,"h":360}}],"user":{"kwarray":["shoes"]},"cur":["EUR","USD"]}`,
+			want:    `{"id":"req-2","seatbid":[{"bid":[{"id":"UUID","impid":"v","price":3,"nurl":"https://ads.example/openrtb2/win?imp_id=v\u0026t=TOKEN\u0026price=${AUCTION_PRICE}","adm":"VAST","cid":"li-video","crid":"li-video","w":640,"h":360}]}],"bidid":"UUID","cur":"USD"}`,
+		},
+		{
+			name:    "floor over the bid",
+			request: `{"id":"req-3","imp":[{"id":"1","tagid":"homepage_top","banner":{"w":300,"h":250},"bidfloor":2.5}],"site":{"keywords":"shoes"}}`,
+		},
+		{
+			name:    "other currency",
+			request: `{"id":"req-4","imp":[{"id":"1","tagid":"homepage_top","banner":{"w":300,"h":250}}],"site":{"keywords":"shoes"},"cur":["EUR"]}`,
+		},
+		{
+			name:    "neither banner nor video",
+			request: `{"id":"req-5","imp":[{"id":"1","tagid":"homepage_top"}],"site":{"keywords":"shoes"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request model.BidRequest
+			if err := json.Unmarshal([]byte(tt.request), &request); err != nil {
+				t.Fatal(err)
+			}
+			response := s.BidOpenRTB(request)
+			if tt.want == "" {
+				if response != nil {
+					t.Errorf("response %+v, want no bid", response)
+				}
+				return
+			}
+			if response == nil {
+				t.Fatal("no bid")
+			}
+			// VAST markup has its own golden test
+			for i := range response.SeatBid[0].Bid {
+				if bid := &response.SeatBid[0].Bid[i]; strings.HasPrefix(bid.AdM, "<?xml") {
+					bid.AdM = "VAST"
+				}
+			}
+			data, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := openRTBTokenPattern.ReplaceAllString(ids.Replace(string(data)), "t=TOKEN")
+			got = openRTBUUIDPattern.ReplaceAllString(got, "UUID")
+			if got != tt.want {
+				t.Errorf("response\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenRTBQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		want    model.WinningAdsQuery
+	}{
+		{
+			name:    "site and user keywords",
+			request: `{"site":{"keywords":"shoes, running ,","kwarray":["sale"],"cat":["IAB17"]},"user":{"id":"user-1","keywords":"sport"}}`,
+			want:    model.WinningAdsQuery{Keywords: []string{"shoes", "running", "sale", "sport"}, Categories: []string{"IAB17"}, UserID: "user-1"},
+		},
+		{
+			name:    "alpha-3 country and bare region",
+			request: `{"device":{"geo":{"country":"USA","region":"ca"}}}`,
+			want:    model.WinningAdsQuery{Country: "US", Region: "US-CA"},
+		},
+		{
+			name:    "device geo over user geo",
+			request: `{"user":{"geo":{"country":"FRA"}},"device":{"geo":{"country":"DEU","region":"DE-BY"}}}`,
+			want:    model.WinningAdsQuery{Country: "DE", Region: "DE-BY"},
+		},
+		{
+			name:    "unknown country",
+			request: `{"device":{"geo":{"country":"XXX"}}}`,
+			want:    model.WinningAdsQuery{},
+		},
+		{
+			name:    "device type over the user agent",
+			request: `{"device":{"ua":"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36","devicetype":3}}`,
+			want:    model.WinningAdsQuery{Device: model.Device{Type: model.DeviceTypeCTV, OS: model.DeviceOSWindows, Browser: model.BrowserChrome}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request model.BidRequest
+			if err := json.Unmarshal([]byte(tt.request), &request); err != nil {
+				t.Fatal(err)
+			}
+			got := openRTBQuery(request)
+			if !slices.Equal(got.Keywords, tt.want.Keywords) || !slices.Equal(got.Categories, tt.want.Categories) ||
+				got.UserID != tt.want.UserID || got.Country != tt.want.Country || got.Region != tt.want.Region || got.Device != tt.want.Device {
+				t.Errorf("openRTBQuery = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"github.com/IBM/sarama"
+)
+
+/*
+ Tracking messages are keyed by line item (APP_PUB_SUB_MESSAGE_KEY), the partitioner picks the partition from the key so all
+ events of a line item land on one partition, in the order they were published. A consumer aggregating per line item
+ then only needs the partitions it owns.
+ murmur2 is the partitioner of the Java client (and Kafka Streams, ksqlDB, Flink...), a consumer re-keying or joining
+ by line item puts the same key on the same partition as we do. hash and crc32 are the sarama and librdkafka ones,
+ random and round_robin ignore the key. Messages without key (APP_PUB_SUB_MESSAGE_KEY=none) are spread randomly by all of them
+ but round_robin.
+*/
+
+// Partitioners, selected with APP_PUB_SUB_PARTITIONER
+const (
+	PartitionerMurmur2    = "murmur2"
+	PartitionerHash       = "hash"
+	PartitionerCRC32      = "crc32"
+	PartitionerRandom     = "random"
+	PartitionerRoundRobin = "round_robin"
+)
+
+// NewPartitioner is the sarama partitioner of APP_PUB_SUB_PARTITIONER, murmur2 for anything unknown
+func NewPartitioner(name string) sarama.PartitionerConstructor {
+	switch name {
+	case PartitionerHash:
+		return sarama.NewHashPartitioner
+	case PartitionerCRC32:
+		return sarama.NewConsistentCRCHashPartitioner
+	case PartitionerRandom:
+		return sarama.NewRandomPartitioner
+	case PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner
+	default:
+		return newMurmur2Partitioner
+	}
+}
+
+// murmur2Partitioner places keyed messages like the Java client: murmur2(key) & 0x7fffffff % partitions
+type murmur2Partitioner struct {
+	random sarama.Partitioner
+}
+
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *murmur2Partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.random.Partition(message, numPartitions)
+	}
+	key, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return int32(murmur2(key)&0x7fffffff) % numPartitions, nil
+}
+
+// RequiresConsistency keeps a key on its partition even while the partition has no leader, ordering over availability
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+// murmur2 is the 32 bit murmur2 of org.apache.kafka.common.utils.Utils, seed 0x9747b28c
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// hashes and partitions of the Java client, org.apache.kafka.common.utils.Utils.murmur2 and toPositive(hash) % partitions
+var murmur2Vectors = []struct {
+	key        string
+	hash       int32
+	partitions map[int32]int32
+}{
+	{key: "21", hash: -973932308, partitions: map[int32]int32{1: 0, 3: 0, 12: 0, 100: 40}},
+	{key: "foobar", hash: -790332482, partitions: map[int32]int32{1: 0, 3: 0, 12: 6, 100: 66}},
+	{key: "a-little-bit-long-string", hash: -985981536, partitions: map[int32]int32{1: 0, 3: 2, 12: 8, 100: 12}},
+	{key: "a-little-bit-longer-string", hash: -1486304829, partitions: map[int32]int32{1: 0, 3: 2, 12: 11, 100: 19}},
+	{key: "lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", hash: -58897971, partitions: map[int32]int32{1: 0, 3: 2, 12: 5, 100: 77}},
+	{key: "abc", hash: 479470107, partitions: map[int32]int32{1: 0, 3: 0, 12: 3, 100: 7}},
+	{key: "", hash: 275646681, partitions: map[int32]int32{1: 0, 3: 0, 12: 9, 100: 81}},
+}
+
+func TestMurmur2(t *testing.T) {
+	for _, tt := range murmur2Vectors {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := int32(murmur2([]byte(tt.key))); got != tt.hash {
+				t.Errorf("murmur2(%q) = %d, want %d", tt.key, got, tt.hash)
+			}
+		})
+	}
+}
+
+func TestMurmur2Partitioner(t *testing.T) {
+	partitioner := NewPartitioner(PartitionerMurmur2)("tracking")
+	if !partitioner.RequiresConsistency() {
+		t.Error("murmur2 partitioner has to keep keys on their partition")
+	}
+	for _, tt := range murmur2Vectors {
+		for numPartitions, want := range tt.partitions {
+			got, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(tt.key)}, numPartitions)
+			if err != nil {
+				t.Fatalf("Partition(%q, %d): %v", tt.key, numPartitions, err)
+			}
+			if got != want {
+				t.Errorf("Partition(%q, %d) = %d, want %d", tt.key, numPartitions, got, want)
+			}
+		}
+	}
+
+	t.Run("unkeyed", func(t *testing.T) {
+		for range 100 {
+			got, err := partitioner.Partition(&sarama.ProducerMessage{}, 12)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got < 0 || got >= 12 {
+				t.Fatalf("unkeyed message on partition %d of 12", got)
+			}
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+
+	"sweng-task/internal/config"
+
+	"go.uber.org/zap"
+)
+
+// Event publisher backends, selected with APP_PUB_SUB_BACKEND
+const (
+	PublisherKafka  = "kafka"
+	PublisherMemory = "memory"
+	PublisherFile   = "file"
+)
+
+// Message is one encoded tracking message, Key and Headers become the kafka record key and headers.
+// An empty Key sends the message without key
+type Message struct {
+	Key     string
+	Value   []byte
+	Headers map[string]string
+}
+
+// EventPublisher takes the tracking messages. Kafka (PubSub) in production, the in-memory one in tests and the
+// newline-delimited file for local runs without a broker
+type EventPublisher interface {
+	// Publish sends one message, an error means it was not taken and the client should retry
+	Publish(msg Message) error
+	// PublishBatch sends the messages together, errs[i] is the error of message i
+	PublishBatch(msgs []Message) []error
+	// Accepting is false when Publish would reject right now
+	Accepting() bool
+	// Close flushes what's still buffered
+	Close()
+}
+
+// NewEventPublisher builds the publisher of APP_PUB_SUB_BACKEND, kafka is connected (or spooling) once it returns
+func NewEventPublisher(log *zap.SugaredLogger, cfg *config.Config) (EventPublisher, error) {
+	switch cfg.PubSub.Backend {
+	case PublisherMemory:
+		return NewMemoryPublisher(log), nil
+	case PublisherFile:
+		return NewFilePublisher(log, cfg)
+	case PublisherKafka:
+		pubSub := NewPubSub(log, cfg)
+		kafkaConfig := config.KafkaConfigLoad(cfg)
+		kafkaConfig.Producer.Partitioner = NewPartitioner(cfg.PubSub.Partitioner)
+		if err := pubSub.Connect(kafkaConfig); err != nil {
+			return nil, err
+		}
+		return pubSub, nil
+	default:
+		return nil, fmt.Errorf("unknown event publisher %q", cfg.PubSub.Backend)
+	}
+}
@@ -1,50 +1,349 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/zap"
-	"os"
+	"sort"
 	"sweng-task/internal/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ Tracking events go to kafka through an async producer, a request only pays for putting the message in a bounded queue.
+ A forwarder hands the queue to sarama, which batches (APP_PUB_SUB_LINGER, APP_PUB_SUB_BATCH_SIZE) and compresses them.
+ When kafka can't keep up the queue fills and APP_PUB_SUB_BACKPRESSURE decides:
+   - drop: the event is lost, counted in kafka_producer_dropped_total
+   - block: the request waits for room in the queue
+   - reject: the request is answered with 503 so the client retries later
+ Delivery errors come back asynchronously and are counted in kafka_producer_errors_total.
+ With the disk spool (APP_PUB_SUB_SPOOL_DIR) nothing is dropped, blocked or rejected until the spool is full: messages
+ kafka can't take right now (broker unreachable at boot, full queue, failed delivery) are spooled and the replayer
+ sends them once kafka is back. While the spool isn't empty new messages are spooled too, so they can't overtake
+ the older ones.
+*/
+
+// Backpressure policies
+const (
+	BackpressureDrop   = "drop"
+	BackpressureBlock  = "block"
+	BackpressureReject = "reject"
+)
+
+// ErrQueueFull is returned by Publish when the queue is full and the policy is reject
+var ErrQueueFull = errors.New("tracking queue is full")
+
+var (
+	producerQueueDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kafka_producer_queue_depth",
+			Help: "Number of messages waiting in the producer queue.",
+		},
+	)
+
+	producerDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_producer_dropped_total",
+			Help: "Number of messages not queued because the queue was full, by backpressure policy.",
+		},
+		[]string{"policy"},
+	)
+
+	producerDelivered = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_producer_delivered_total",
+			Help: "Number of messages acknowledged by kafka.",
+		},
+		[]string{"topic"},
+	)
+
+	producerErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_producer_errors_total",
+			Help: "Number of messages kafka failed to take after all retries.",
+		},
+		[]string{"topic"},
+	)
 )
 
 type PubSub struct {
 	logs       *zap.SugaredLogger
 	cfg        *config.Config
-	connection sarama.SyncProducer
+	connection sarama.AsyncProducer
+	queue      chan *sarama.ProducerMessage
+	// forwarder and the delivery report readers
+	wg        sync.WaitGroup
+	connected atomic.Bool
+	// nil when APP_PUB_SUB_SPOOL_DIR is empty. Spooled messages are replayed with a sync producer, a batch is only
+	// removed from the spool once kafka acknowledged it
+	spool          *Spool
+	replayProducer sarama.SyncProducer
+	stop           chan struct{}
+	replayer       sync.WaitGroup
 }
 
 func NewPubSub(log *zap.SugaredLogger, cfg *config.Config) *PubSub {
 	return &PubSub{
-		logs: log,
-		cfg:  cfg,
+		logs:  log,
+		cfg:   cfg,
+		queue: make(chan *sarama.ProducerMessage, cfg.PubSub.QueueSize),
+		stop:  make(chan struct{}),
 	}
 }
 
+// Connect opens the spool and the kafka producer. With the spool an unreachable broker is not an error, events are
+// spooled and the replayer keeps trying to connect
 func (p *PubSub) Connect(config *sarama.Config) error {
-	brokers := []string{os.Getenv("BROKER")}
-	producer, err := sarama.NewSyncProducer([]string{p.cfg.PubSub.Broker}, config)
+	if p.cfg.PubSub.SpoolDir != "" {
+		spool, err := NewSpool(p.logs, p.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to open spool %s: %w", p.cfg.PubSub.SpoolDir, err)
+		}
+		p.spool = spool
+	}
+
+	err := p.connect(config)
+	if err != nil && p.spool == nil {
+		return err
+	}
 	if err != nil {
-		p.logs.Fatalw("Failed to create Kafka producer",
+		p.logs.Warnw("Kafka unreachable, tracking events are spooled until it's back",
 			"error", err,
-			"brokers", brokers,
+			"brokers", p.cfg.PubSub.Broker,
+			"spool", p.cfg.PubSub.SpoolDir,
 		)
-	} else {
-		p.logs.Info("Kafka producer connected to %v", brokers)
 	}
+	if p.spool != nil {
+		p.replayer.Add(1)
+		go p.replay(config)
+	}
+	return nil
+}
+
+func (p *PubSub) connect(config *sarama.Config) error {
+	producer, err := sarama.NewAsyncProducer([]string{p.cfg.PubSub.Broker}, config)
+	if err != nil {
+		return err
+	}
+	if p.spool != nil {
+		// sync producer has to get the successes back
+		replayConfig := *config
+		replayConfig.Producer.Return.Successes = true
+		replayProducer, err := sarama.NewSyncProducer([]string{p.cfg.PubSub.Broker}, &replayConfig)
+		if err != nil {
+			producer.Close()
+			return err
+		}
+		p.replayProducer = replayProducer
+	}
+	p.logs.Infow("Kafka producer connected", "brokers", p.cfg.PubSub.Broker)
 	p.connection = producer
+
+	p.wg.Add(2)
+	go p.forward()
+	go p.reportErrors()
+	if config.Producer.Return.Successes {
+		p.wg.Add(1)
+		go p.reportSuccesses()
+	}
+	p.connected.Store(true)
 	return nil
 }
 
-func (p *PubSub) Connection() sarama.SyncProducer {
+func (p *PubSub) Connection() sarama.AsyncProducer {
 	return p.connection
 }
 
-func (p *PubSub) Publish(data string) {
-	_, _, err := p.connection.SendMessage(&sarama.ProducerMessage{
-		Topic: p.cfg.PubSub.Topic,
-		Value: sarama.StringEncoder(data),
-	})
-	if err != nil {
-		p.logs.Warnf(err.Error())
+// Accepting is false when a full queue is rejected and there is no room left for the message, handlers check it
+// before recording an event so the client can retry it
+func (p *PubSub) Accepting() bool {
+	if p.cfg.PubSub.Backpressure != BackpressureReject {
+		return true
+	}
+	if p.spool != nil && !p.spool.Full() {
+		return true
+	}
+	return p.connected.Load() && len(p.queue) < cap(p.queue)
+}
+
+// Publish queues the message or spools it, only the reject policy returns an error (ErrQueueFull)
+func (p *PubSub) Publish(message Message) error {
+	msg := p.producerMessage(message)
+	if p.connected.Load() && (p.spool == nil || !p.spool.Pending()) {
+		select {
+		case p.queue <- msg:
+			producerQueueDepth.Set(float64(len(p.queue)))
+			return nil
+		default:
+		}
+		if p.spool == nil && p.cfg.PubSub.Backpressure == BackpressureBlock {
+			p.queue <- msg
+			producerQueueDepth.Set(float64(len(p.queue)))
+			return nil
+		}
+	}
+	if p.spool != nil {
+		err := p.spool.Append(message)
+		if err == nil {
+			return nil
+		}
+		p.logs.Warnw("Failed to spool message", "error", err)
+	}
+
+	producerDropped.WithLabelValues(p.cfg.PubSub.Backpressure).Inc()
+	if p.cfg.PubSub.Backpressure == BackpressureReject {
+		return ErrQueueFull
+	}
+	p.logs.Debugw("Tracking queue full, message dropped", "queue_size", cap(p.queue))
+	return nil
+}
+
+// PublishBatch queues all messages, the error of message i is errs[i] (nil when it was queued).
+// The producer sends them together as long as they fit in APP_PUB_SUB_BATCH_SIZE
+func (p *PubSub) PublishBatch(msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	failed := 0
+	for i, msg := range msgs {
+		if errs[i] = p.Publish(msg); errs[i] != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		p.logs.Warnw("Failed to publish part of the batch", "failed", failed, "batch", len(msgs))
+	}
+	return errs
+}
+
+// Close stops taking messages and flushes the queue to kafka, call it once the server stopped taking requests.
+// Whatever kafka doesn't take stays in the spool for the next start
+func (p *PubSub) Close() {
+	close(p.stop)
+	p.replayer.Wait()
+	close(p.queue)
+	if !p.connected.Load() {
+		// nothing ever read the queue, and with the spool nothing went to it
+		for msg := range p.queue {
+			producerDropped.WithLabelValues(p.cfg.PubSub.Backpressure).Inc()
+			p.logs.Warnw("Tracking message lost on shutdown", "topic", msg.Topic)
+		}
+	}
+	p.wg.Wait()
+	producerQueueDepth.Set(0)
+	if p.replayProducer != nil {
+		p.replayProducer.Close()
+	}
+	if p.spool != nil {
+		if err := p.spool.Close(); err != nil {
+			p.logs.Warnw("Failed to close spool", "error", err)
+		}
+	}
+}
+
+// replay connects to kafka if it isn't yet and sends the spooled messages, every APP_PUB_SUB_SPOOL_RETRY_INTERVAL
+func (p *PubSub) replay(config *sarama.Config) {
+	defer p.replayer.Done()
+	ticker := time.NewTicker(p.cfg.PubSub.SpoolRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		if !p.connected.Load() {
+			if err := p.connect(config); err != nil {
+				p.logs.Debugw("Kafka still unreachable", "error", err)
+				continue
+			}
+		}
+		if !p.spool.Pending() {
+			continue
+		}
+		if err := p.spool.Replay(p.sendBatch); err != nil {
+			p.logs.Warnw("Spool replay stopped, retrying later", "error", err)
+			continue
+		}
+		p.logs.Info("Spool replayed, tracking events go to kafka directly again")
+	}
+}
+
+// sendBatch sends spooled messages, in order and acknowledged
+func (p *PubSub) sendBatch(msgs []Message) error {
+	messages := make([]*sarama.ProducerMessage, len(msgs))
+	for i, msg := range msgs {
+		messages[i] = p.producerMessage(msg)
+	}
+	return p.replayProducer.SendMessages(messages)
+}
+
+// producerMessage turns the message into a kafka record on the tracking topic, headers sorted by name.
+// The partitioner (APP_PUB_SUB_PARTITIONER) picks the partition from the key
+func (p *PubSub) producerMessage(message Message) *sarama.ProducerMessage {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+	for key, value := range message.Headers {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+	sort.Slice(headers, func(i, j int) bool { return string(headers[i].Key) < string(headers[j].Key) })
+	msg := &sarama.ProducerMessage{
+		Topic:   p.cfg.PubSub.Topic,
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if message.Key != "" {
+		msg.Key = sarama.StringEncoder(message.Key)
+	}
+	return msg
+}
+
+// spoolMessage is the message back from a kafka record
+func spoolMessage(msg *sarama.ProducerMessage) Message {
+	value, _ := msg.Value.Encode()
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	message := Message{Value: value, Headers: headers}
+	if msg.Key != nil {
+		key, _ := msg.Key.Encode()
+		message.Key = string(key)
+	}
+	return message
+}
+
+// forward hands the queue to the producer, closing the producer when the queue is closed and drained
+func (p *PubSub) forward() {
+	defer p.wg.Done()
+	for msg := range p.queue {
+		p.connection.Input() <- msg
+		producerQueueDepth.Set(float64(len(p.queue)))
+	}
+	// flushes what sarama still buffers, Errors and Successes are closed once it's done
+	p.connection.AsyncClose()
+}
+
+func (p *PubSub) reportErrors() {
+	defer p.wg.Done()
+	for err := range p.connection.Errors() {
+		producerErrors.WithLabelValues(err.Msg.Topic).Inc()
+		p.logs.Warnw("Failed to deliver message", "topic", err.Msg.Topic, "error", err.Err)
+		if p.spool == nil {
+			continue
+		}
+		// kept for the replayer, it goes out once the broker is back
+		if err := p.spool.Append(spoolMessage(err.Msg)); err != nil {
+			p.logs.Warnw("Failed to spool undelivered message", "error", err)
+		}
+	}
+}
+
+func (p *PubSub) reportSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.connection.Successes() {
+		producerDelivered.WithLabelValues(msg.Topic).Inc()
 	}
 }
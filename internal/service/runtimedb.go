@@ -1,6 +1,11 @@
 package service
 
-import "go.uber.org/zap"
+import (
+	"strings"
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
 
 type RunTimeDB struct {
 	log            *zap.SugaredLogger
@@ -9,16 +14,81 @@ type RunTimeDB struct {
 	Placements     map[string][]string
 	TargetFree     map[string]float64
 	ParameterCount map[string]int
+	// Inverted exclusion index, keyword/category -> line items that must never be served on it
+	ExcludedKeywords   map[string][]string
+	ExcludedCategories map[string][]string
+	// Partial keyword matching, trie holds the same terms as Keywords. Line items have to opt in (or the placement)
+	KeywordTrie *KeywordTrie
+	PrefixMatch map[string]bool
+	FuzzyMatch  map[string]bool
+	// Geo targeting, line items in GeoTargeted only match requests hitting one of their countries, regions or areas
+	GeoCountries map[string][]string
+	GeoRegions   map[string][]string
+	GeoAreas     *GeoIndex
+	GeoTargeted  map[string]bool
+	// Device targeting, each dimension is an allow list
+	DeviceTypes      *TargetingIndex
+	OperatingSystems *TargetingIndex
+	Browsers         *TargetingIndex
+	// line item -> competitive categories, two line items sharing one can't be served in the same response
+	CompetitiveCategories map[string][]string
+}
+
+// TargetingIndex is an inverted index for an allow list dimension, line items with an empty list are not restricted
+type TargetingIndex struct {
+	Values   map[string][]string
+	Targeted map[string]bool
+}
+
+func NewTargetingIndex() *TargetingIndex {
+	return &TargetingIndex{
+		Values:   map[string][]string{},
+		Targeted: map[string]bool{},
+	}
+}
+
+func (t *TargetingIndex) Add(values []string, lineItemId string) {
+	for _, value := range values {
+		t.Values[value] = append(t.Values[value], lineItemId)
+		t.Targeted[lineItemId] = true
+	}
+}
+
+// Allowed returns the set of targeted line items allowing the value
+func (t *TargetingIndex) Allowed(value string) map[string]bool {
+	allowed := make(map[string]bool, len(t.Values[value]))
+	for _, id := range t.Values[value] {
+		allowed[id] = true
+	}
+	return allowed
+}
+
+// Blocks tells if the line item targets this dimension but the request value is not on its list
+func (t *TargetingIndex) Blocks(lineItemId string, allowed map[string]bool) bool {
+	return t.Targeted[lineItemId] && !allowed[lineItemId]
 }
 
 func NewRunTimeDB(log *zap.SugaredLogger) *RunTimeDB {
 	return &RunTimeDB{
-		log:            log,
-		Keywords:       map[string][]string{},
-		Categories:     map[string][]string{},
-		Placements:     map[string][]string{},
-		TargetFree:     map[string]float64{},
-		ParameterCount: map[string]int{},
+		log:                   log,
+		Keywords:              map[string][]string{},
+		Categories:            map[string][]string{},
+		Placements:            map[string][]string{},
+		TargetFree:            map[string]float64{},
+		ParameterCount:        map[string]int{},
+		ExcludedKeywords:      map[string][]string{},
+		ExcludedCategories:    map[string][]string{},
+		KeywordTrie:           NewKeywordTrie(),
+		PrefixMatch:           map[string]bool{},
+		FuzzyMatch:            map[string]bool{},
+		GeoCountries:          map[string][]string{},
+		GeoRegions:            map[string][]string{},
+		GeoAreas:              NewGeoIndex(1.0),
+		GeoTargeted:           map[string]bool{},
+		DeviceTypes:           NewTargetingIndex(),
+		OperatingSystems:      NewTargetingIndex(),
+		Browsers:              NewTargetingIndex(),
+		CompetitiveCategories: map[string][]string{},
 	}
 }
 
@@ -28,6 +98,7 @@ func (r *RunTimeDB) AddKeyWords(Keywords []string, advertisementId string) {
 			r.Keywords[keyword] = append(r.Keywords[keyword], advertisementId)
 		} else {
 			r.Keywords[keyword] = []string{advertisementId}
+			r.KeywordTrie.Insert(keyword)
 		}
 	}
 }
@@ -40,6 +111,43 @@ func (r *RunTimeDB) GetKeyWords(keyword string) []string {
 	}
 }
 
+// GetKeyWordsAny returns every line item matching at least one of the keywords (synonym expansion), without duplicates
+func (r *RunTimeDB) GetKeyWordsAny(keywords []string) []string {
+	if len(keywords) == 1 {
+		return r.GetKeyWords(keywords[0])
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, keyword := range keywords {
+		for _, id := range r.Keywords[keyword] {
+			if !seen[id] {
+				seen[id] = true
+				result = append(result, id)
+			}
+		}
+	}
+	return result
+}
+
+// GetKeyWordsByPrefix returns indexed keywords (not line items) that start with the prefix
+func (r *RunTimeDB) GetKeyWordsByPrefix(prefix string) []string {
+	return r.KeywordTrie.WithPrefix(prefix)
+}
+
+// GetKeyWordsByDistance returns indexed keywords (not line items) within maxDistance edits of the keyword
+func (r *RunTimeDB) GetKeyWordsByDistance(keyword string, maxDistance int) []FuzzyMatch {
+	return r.KeywordTrie.WithinDistance(keyword, maxDistance)
+}
+
+func (r *RunTimeDB) AddPartialMatch(lineItemId string, prefix bool, fuzzy bool) {
+	if prefix {
+		r.PrefixMatch[lineItemId] = true
+	}
+	if fuzzy {
+		r.FuzzyMatch[lineItemId] = true
+	}
+}
+
 func (r *RunTimeDB) AddCategory(Categories []string, lineItemId string) {
 	for _, category := range Categories {
 		if _, ok := r.Categories[category]; ok {
@@ -58,6 +166,24 @@ func (r *RunTimeDB) GetCategory(category string) []string {
 	}
 }
 
+// GetCategoriesAny returns every line item in at least one of the categories, without duplicates
+func (r *RunTimeDB) GetCategoriesAny(categories []string) []string {
+	if len(categories) == 1 {
+		return r.GetCategory(categories[0])
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, category := range categories {
+		for _, id := range r.Categories[category] {
+			if !seen[id] {
+				seen[id] = true
+				result = append(result, id)
+			}
+		}
+	}
+	return result
+}
+
 func (r *RunTimeDB) AddPlacements(placement string, lineItemId string) {
 	if _, ok := r.Placements[placement]; ok {
 		r.Placements[placement] = append(r.Placements[placement], lineItemId)
@@ -100,3 +226,71 @@ func (r *RunTimeDB) AddParameterCount(advertiserId string, parameters int) {
 func (r *RunTimeDB) GetParameterCount() map[string]int {
 	return r.ParameterCount
 }
+
+func (r *RunTimeDB) AddExcludedKeywords(keywords []string, lineItemId string) {
+	for _, keyword := range keywords {
+		r.ExcludedKeywords[keyword] = append(r.ExcludedKeywords[keyword], lineItemId)
+	}
+}
+
+func (r *RunTimeDB) AddExcludedCategories(categories []string, lineItemId string) {
+	for _, category := range categories {
+		r.ExcludedCategories[category] = append(r.ExcludedCategories[category], lineItemId)
+	}
+}
+
+// GetExclusions returns the set of line items that opted out of any of the requested keywords or the category,
+// these have to be dropped before any scoring happens
+func (r *RunTimeDB) GetExclusions(keywords []string, categories []string) map[string]bool {
+	excluded := map[string]bool{}
+	for _, keyword := range keywords {
+		for _, id := range r.ExcludedKeywords[keyword] {
+			excluded[id] = true
+		}
+	}
+	for _, category := range categories {
+		for _, id := range r.ExcludedCategories[category] {
+			excluded[id] = true
+		}
+	}
+	return excluded
+}
+
+func (r *RunTimeDB) AddGeo(geo *model.GeoTargeting, lineItemId string) {
+	if geo == nil || (len(geo.Countries) == 0 && len(geo.Regions) == 0 && len(geo.Areas) == 0) {
+		return
+	}
+	r.GeoTargeted[lineItemId] = true
+	for _, country := range geo.Countries {
+		country = strings.ToUpper(country)
+		r.GeoCountries[country] = append(r.GeoCountries[country], lineItemId)
+	}
+	for _, region := range geo.Regions {
+		region = strings.ToUpper(region)
+		r.GeoRegions[region] = append(r.GeoRegions[region], lineItemId)
+	}
+	for _, area := range geo.Areas {
+		r.GeoAreas.Insert(lineItemId, area)
+	}
+}
+
+// GetGeoMatches returns the geo targeted line items the request location satisfies, lat/lon are optional
+func (r *RunTimeDB) GetGeoMatches(country string, region string, lat *float64, lon *float64) map[string]bool {
+	matches := map[string]bool{}
+	if lat != nil && lon != nil {
+		matches = r.GeoAreas.Query(*lat, *lon)
+	}
+	for _, id := range r.GeoCountries[strings.ToUpper(country)] {
+		matches[id] = true
+	}
+	for _, id := range r.GeoRegions[strings.ToUpper(region)] {
+		matches[id] = true
+	}
+	return matches
+}
+
+func (r *RunTimeDB) AddCompetitiveCategories(categories []string, lineItemId string) {
+	if len(categories) > 0 {
+		r.CompetitiveCategories[lineItemId] = categories
+	}
+}
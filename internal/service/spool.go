@@ -0,0 +1,342 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sweng-task/internal/config"
+
+	"go.uber.org/zap"
+)
+
+/*
+ Spool keeps the tracking messages kafka can't take right now on disk, so a broker outage doesn't lose them.
+ It's a segmented append-only log under APP_PUB_SUB_SPOOL_DIR: 00000000000000000001.log, 00000000000000000002.log, ...
+ one message per line, {"key": "...", "headers": {...}, "value": "<base64>"}. A segment is closed at APP_PUB_SUB_SPOOL_SEGMENT_BYTES and never
+ written again, a restart always starts a new one so a record torn by a crash stays at the end of its segment.
+ Replay reads the closed segments oldest first and deletes each one once it's sent, replay.offset remembers how far
+ into the oldest segment it got so a restart doesn't send it again from the start.
+ The spool stops taking messages at APP_PUB_SUB_SPOOL_MAX_BYTES.
+*/
+
+// ErrSpoolFull is returned by Append when the spool reached APP_PUB_SUB_SPOOL_MAX_BYTES
+var ErrSpoolFull = errors.New("tracking spool is full")
+
+const (
+	spoolSegmentExt  = ".log"
+	spoolCheckpoint  = "replay.offset"
+	spoolReplayBatch = 500
+)
+
+var (
+	spoolBytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tracking_spool_bytes",
+			Help: "Size of the tracking messages waiting in the disk spool.",
+		},
+	)
+
+	spoolWritten = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tracking_spool_written_total",
+			Help: "Number of tracking messages written to the disk spool.",
+		},
+	)
+
+	spoolReplayed = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tracking_spool_replayed_total",
+			Help: "Number of spooled tracking messages sent to kafka.",
+		},
+	)
+)
+
+// spoolRecord is one line of a segment
+type spoolRecord struct {
+	Key     string            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Value   []byte            `json:"value"`
+}
+
+type Spool struct {
+	log          *zap.SugaredLogger
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+	mu           sync.Mutex
+	current      *os.File
+	currentSeq   uint64
+	currentSize  int64
+	// bytes of all segments on disk, replay shrinks it without holding mu
+	size atomic.Int64
+}
+
+func NewSpool(log *zap.SugaredLogger, cfg *config.Config) (*Spool, error) {
+	s := &Spool{
+		log:          log,
+		dir:          cfg.PubSub.SpoolDir,
+		segmentBytes: cfg.PubSub.SpoolSegmentBytes,
+		maxBytes:     cfg.PubSub.SpoolMaxBytes,
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, seq := range segments {
+		info, err := os.Stat(s.segmentPath(seq))
+		if err != nil {
+			return nil, err
+		}
+		s.size.Add(info.Size())
+		s.currentSeq = seq
+	}
+	spoolBytes.Set(float64(s.size.Load()))
+	if len(segments) > 0 {
+		log.Infow("Tracking spool has messages from a previous run", "dir", s.dir, "segments", len(segments), "bytes", s.size.Load())
+	}
+	return s, nil
+}
+
+// Append writes the message at the end of the current segment
+func (s *Spool) Append(msg Message) error {
+	line, err := json.Marshal(spoolRecord{Key: msg.Key, Headers: msg.Headers, Value: msg.Value})
+	if err != nil {
+		return err
+	}
+	data := string(line)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := int64(len(data) + 1)
+	if s.size.Load()+record > s.maxBytes {
+		return ErrSpoolFull
+	}
+	if s.current == nil || s.currentSize+record > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.current.WriteString(data + "\n"); err != nil {
+		return err
+	}
+	s.currentSize += record
+	spoolWritten.Inc()
+	spoolBytes.Set(float64(s.size.Add(record)))
+	return nil
+}
+
+// Pending is true while there are spooled messages left to replay
+func (s *Spool) Pending() bool {
+	return s.size.Load() > 0
+}
+
+func (s *Spool) Full() bool {
+	return s.size.Load() >= s.maxBytes
+}
+
+// Replay sends the spooled messages oldest first in batches, stopping at the first failed send.
+// The lock is only held to close the segment being written, appends go on while the closed segments are sent and the
+// segments written meanwhile are sent in the next round. Once a round finds none Replay returns nil, the spool is empty
+// and new messages can go to kafka directly without overtaking spooled ones
+func (s *Spool) Replay(send func([]Message) error) error {
+	for {
+		s.mu.Lock()
+		segments, err := s.closedSegments()
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if len(segments) == 0 {
+			return nil
+		}
+		if err := s.replaySegments(segments, send); err != nil {
+			return err
+		}
+	}
+}
+
+// closedSegments closes the segment being written and lists all segments, callers hold the lock
+func (s *Spool) closedSegments() ([]uint64, error) {
+	if err := s.closeCurrent(); err != nil {
+		return nil, err
+	}
+	return s.segments()
+}
+
+func (s *Spool) replaySegments(segments []uint64, send func([]Message) error) error {
+	checkpointSeq, checkpointOffset := s.readCheckpoint()
+	for _, seq := range segments {
+		offset := int64(0)
+		if seq == checkpointSeq {
+			offset = checkpointOffset
+		}
+		if err := s.replaySegment(seq, offset, send); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spool) replaySegment(seq uint64, offset int64, send func([]Message) error) error {
+	path := s.segmentPath(seq)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	batch := make([]Message, 0, spoolReplayBatch)
+	batchBytes := int64(0)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == nil {
+			if msg, err := decodeSpoolRecord(strings.TrimSuffix(line, "\n")); err != nil {
+				s.log.Warnw("Skipping unreadable spool record", "segment", path, "error", err)
+			} else {
+				batch = append(batch, msg)
+			}
+			batchBytes += int64(len(line))
+		} else if err != io.EOF {
+			return err
+		} else if line != "" {
+			// no newline, the write was cut by a crash
+			s.log.Warnw("Skipping torn record at the end of a spool segment", "segment", path, "bytes", len(line))
+		}
+		if len(batch) == spoolReplayBatch || (err == io.EOF && len(batch) > 0) {
+			if err := send(batch); err != nil {
+				return err
+			}
+			offset += batchBytes
+			spoolReplayed.Add(float64(len(batch)))
+			s.writeCheckpoint(seq, offset)
+			batch, batchBytes = batch[:0], 0
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(s.dir, spoolCheckpoint))
+	spoolBytes.Set(float64(s.size.Add(-info.Size())))
+	s.log.Infow("Spool segment replayed", "segment", path, "bytes", info.Size())
+	return nil
+}
+
+func decodeSpoolRecord(line string) (Message, error) {
+	var record spoolRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return Message{}, err
+	}
+	return Message{Key: record.Key, Value: record.Value, Headers: record.Headers}, nil
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeCurrent()
+}
+
+// rotate closes the current segment and opens the next one, callers hold the lock
+func (s *Spool) rotate() error {
+	if err := s.closeCurrent(); err != nil {
+		return err
+	}
+	s.currentSeq++
+	file, err := os.OpenFile(s.segmentPath(s.currentSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	s.current, s.currentSize = file, 0
+	return nil
+}
+
+// closeCurrent syncs and closes the segment being written, the next Append opens a new one. Callers hold the lock
+func (s *Spool) closeCurrent() error {
+	if s.current == nil {
+		return nil
+	}
+	file := s.current
+	s.current = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// segments lists the sequence numbers of the segments on disk, oldest first
+func (s *Spool) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := []uint64{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+func (s *Spool) readCheckpoint() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCheckpoint))
+	if err != nil {
+		return 0, 0
+	}
+	var seq uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &offset); err != nil {
+		s.log.Warnw("Ignoring unreadable spool checkpoint", "error", err)
+		return 0, 0
+	}
+	return seq, offset
+}
+
+func (s *Spool) writeCheckpoint(seq uint64, offset int64) {
+	path := filepath.Join(s.dir, spoolCheckpoint)
+	// written aside and renamed, a crash never leaves half a checkpoint
+	if err := os.WriteFile(path+".tmp", []byte(fmt.Sprintf("%d %d", seq, offset)), 0o644); err != nil {
+		s.log.Warnw("Failed to write spool checkpoint", "error", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		s.log.Warnw("Failed to write spool checkpoint", "error", err)
+	}
+}
@@ -0,0 +1,384 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/config"
+)
+
+// spoolTestMessage is message i, all of them encode to records of the same size
+func spoolTestMessage(i int) Message {
+	return Message{
+		Key:     fmt.Sprintf("key-%04d", i),
+		Value:   []byte(fmt.Sprintf(`{"event":%04d}`, i)),
+		Headers: map[string]string{"event_type": "impression"},
+	}
+}
+
+// spoolTestRecordSize is the size of one record on disk, newline included
+func spoolTestRecordSize(t *testing.T) int64 {
+	msg := spoolTestMessage(0)
+	line, err := json.Marshal(spoolRecord{Key: msg.Key, Headers: msg.Headers, Value: msg.Value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(line) + 1)
+}
+
+func newTestSpool(t *testing.T, dir string, segmentBytes, maxBytes int64) *Spool {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.PubSub.SpoolDir = dir
+	cfg.PubSub.SpoolSegmentBytes = segmentBytes
+	cfg.PubSub.SpoolMaxBytes = maxBytes
+	spool, err := NewSpool(zap.NewNop().Sugar(), cfg)
+	if err != nil {
+		t.Fatalf("NewSpool: %v", err)
+	}
+	return spool
+}
+
+func appendTestMessages(t *testing.T, spool *Spool, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := spool.Append(spoolTestMessage(i)); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// replayKeys replays the spool and returns the keys it sent, in order
+func replayKeys(t *testing.T, spool *Spool) []string {
+	t.Helper()
+	keys := []string{}
+	err := spool.Replay(func(batch []Message) error {
+		for _, msg := range batch {
+			keys = append(keys, msg.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return keys
+}
+
+func testKeys(from, to int) []string {
+	keys := []string{}
+	for i := from; i < to; i++ {
+		keys = append(keys, spoolTestMessage(i).Key)
+	}
+	return keys
+}
+
+func TestSpoolAppendRotatesSegments(t *testing.T) {
+	record := spoolTestRecordSize(t)
+	tests := []struct {
+		name           string
+		segmentRecords int64
+		messages       int
+		wantSegments   int
+	}{
+		{name: "empty spool has no segment", segmentRecords: 3, messages: 0, wantSegments: 0},
+		{name: "fits one segment", segmentRecords: 3, messages: 3, wantSegments: 1},
+		{name: "rotates when the segment is full", segmentRecords: 3, messages: 4, wantSegments: 2},
+		{name: "rotates on every record", segmentRecords: 1, messages: 5, wantSegments: 5},
+		{name: "record larger than a segment", segmentRecords: 0, messages: 2, wantSegments: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			spool := newTestSpool(t, dir, record*tt.segmentRecords, 1<<20)
+			appendTestMessages(t, spool, 0, tt.messages)
+			if err := spool.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := len(segmentFiles(t, dir)); got != tt.wantSegments {
+				t.Errorf("segments = %d, want %d", got, tt.wantSegments)
+			}
+			if got := spool.Pending(); got != (tt.messages > 0) {
+				t.Errorf("Pending = %v, want %v", got, tt.messages > 0)
+			}
+		})
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	record := spoolTestRecordSize(t)
+	tests := []struct {
+		name           string
+		segmentRecords int64
+		messages       int
+		// damage runs on the closed spool before it's reopened, the way a crash leaves it
+		damage func(t *testing.T, dir string)
+		// appended after the reopen
+		more int
+		want []string
+	}{
+		{
+			name:           "one segment",
+			segmentRecords: 10,
+			messages:       4,
+			want:           testKeys(0, 4),
+		},
+		{
+			name:           "segments oldest first",
+			segmentRecords: 2,
+			messages:       7,
+			want:           testKeys(0, 7),
+		},
+		{
+			name:           "reopen continues with a new segment",
+			segmentRecords: 10,
+			messages:       3,
+			more:           2,
+			want:           testKeys(0, 5),
+		},
+		{
+			name:           "crash mid-record skips the torn record",
+			segmentRecords: 10,
+			messages:       3,
+			damage: func(t *testing.T, dir string) {
+				appendToLastSegment(t, dir, `{"key":"key-0003","val`)
+			},
+			more: 0,
+			want: testKeys(0, 3),
+		},
+		{
+			name:           "crash mid-record then more appends",
+			segmentRecords: 10,
+			messages:       3,
+			damage: func(t *testing.T, dir string) {
+				appendToLastSegment(t, dir, `{"key":"key-0003","val`)
+			},
+			more: 2,
+			want: append(testKeys(0, 3), testKeys(3, 5)...),
+		},
+		{
+			name:           "unreadable record is skipped",
+			segmentRecords: 10,
+			messages:       2,
+			damage: func(t *testing.T, dir string) {
+				appendToLastSegment(t, dir, "not json\n")
+			},
+			more: 1,
+			want: testKeys(0, 3),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			spool := newTestSpool(t, dir, record*tt.segmentRecords, 1<<20)
+			appendTestMessages(t, spool, 0, tt.messages)
+			if err := spool.Close(); err != nil {
+				t.Fatal(err)
+			}
+			before := segmentFiles(t, dir)
+			if tt.damage != nil {
+				tt.damage(t, dir)
+			}
+
+			spool = newTestSpool(t, dir, record*tt.segmentRecords, 1<<20)
+			if !spool.Pending() {
+				t.Fatal("reopened spool has nothing pending")
+			}
+			appendTestMessages(t, spool, tt.messages, tt.messages+tt.more)
+			if tt.more > 0 {
+				// a restart never writes into the segments of the previous run
+				after := segmentFiles(t, dir)
+				if len(after) <= len(before) || after[len(before)-1] != before[len(before)-1] {
+					t.Errorf("segments after reopen = %v, before = %v", after, before)
+				}
+			}
+
+			if got := replayKeys(t, spool); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("replayed %v, want %v", got, tt.want)
+			}
+			if spool.Pending() {
+				t.Error("spool still pending after replay")
+			}
+			if files := segmentFiles(t, dir); len(files) != 0 {
+				t.Errorf("segments left after replay: %v", files)
+			}
+			if _, err := os.Stat(filepath.Join(dir, spoolCheckpoint)); !os.IsNotExist(err) {
+				t.Errorf("checkpoint left after replay: %v", err)
+			}
+		})
+	}
+}
+
+func appendToLastSegment(t *testing.T, dir string, data string) {
+	t.Helper()
+	files := segmentFiles(t, dir)
+	if len(files) == 0 {
+		t.Fatal("no segment to damage")
+	}
+	file, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolReplayCheckpoint(t *testing.T) {
+	record := spoolTestRecordSize(t)
+	messages := spoolReplayBatch*2 + 100
+	tests := []struct {
+		name string
+		// the send of this batch fails, counting from 1
+		failBatch      int
+		wantSent       int
+		wantCheckpoint string
+	}{
+		{
+			name:           "first batch fails",
+			failBatch:      1,
+			wantSent:       0,
+			wantCheckpoint: "",
+		},
+		{
+			name:           "second batch fails",
+			failBatch:      2,
+			wantSent:       spoolReplayBatch,
+			wantCheckpoint: fmt.Sprintf("1 %d", record*spoolReplayBatch),
+		},
+		{
+			name:           "last batch fails",
+			failBatch:      3,
+			wantSent:       spoolReplayBatch * 2,
+			wantCheckpoint: fmt.Sprintf("1 %d", record*spoolReplayBatch*2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			spool := newTestSpool(t, dir, 1<<30, 1<<30)
+			appendTestMessages(t, spool, 0, messages)
+
+			sent := []string{}
+			batches := 0
+			errBroker := errors.New("broker down")
+			err := spool.Replay(func(batch []Message) error {
+				batches++
+				if batches == tt.failBatch {
+					return errBroker
+				}
+				for _, msg := range batch {
+					sent = append(sent, msg.Key)
+				}
+				return nil
+			})
+			if !errors.Is(err, errBroker) {
+				t.Fatalf("Replay error = %v, want %v", err, errBroker)
+			}
+			if len(sent) != tt.wantSent {
+				t.Errorf("sent %d messages before the failure, want %d", len(sent), tt.wantSent)
+			}
+			checkpoint, _ := os.ReadFile(filepath.Join(dir, spoolCheckpoint))
+			if string(checkpoint) != tt.wantCheckpoint {
+				t.Errorf("checkpoint = %q, want %q", checkpoint, tt.wantCheckpoint)
+			}
+
+			// the checkpoint outlives a restart, nothing already sent is sent again
+			if err := spool.Close(); err != nil {
+				t.Fatal(err)
+			}
+			spool = newTestSpool(t, dir, 1<<30, 1<<30)
+			sent = append(sent, replayKeys(t, spool)...)
+			if got, want := strings.Join(sent, ","), strings.Join(testKeys(0, messages), ","); got != want {
+				t.Errorf("sent %d messages over both replays, want each of the %d once in order", len(sent), messages)
+			}
+		})
+	}
+}
+
+func TestSpoolAppendDuringReplay(t *testing.T) {
+	record := spoolTestRecordSize(t)
+	spool := newTestSpool(t, t.TempDir(), record*2, 1<<20)
+	appendTestMessages(t, spool, 0, 3)
+
+	// every send has a request spool one more message meanwhile, the replay sends it in its next round
+	next := 3
+	sent := []string{}
+	err := spool.Replay(func(batch []Message) error {
+		for _, msg := range batch {
+			sent = append(sent, msg.Key)
+		}
+		if next == 6 {
+			return nil
+		}
+		appended := make(chan error, 1)
+		go func(i int) { appended <- spool.Append(spoolTestMessage(i)) }(next)
+		select {
+		case err := <-appended:
+			if err != nil {
+				t.Errorf("Append during replay: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Append blocked while the replay was sending")
+		}
+		next++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if got, want := strings.Join(sent, ","), strings.Join(testKeys(0, 6), ","); got != want {
+		t.Errorf("replayed %s, want %s", got, want)
+	}
+	if spool.Pending() {
+		t.Error("spool still pending after replay")
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	record := spoolTestRecordSize(t)
+	tests := []struct {
+		name       string
+		maxRecords int64
+		messages   int
+		wantErr    error
+		wantFull   bool
+	}{
+		{name: "below the limit", maxRecords: 3, messages: 2, wantErr: nil, wantFull: false},
+		{name: "at the limit", maxRecords: 3, messages: 3, wantErr: nil, wantFull: true},
+		{name: "over the limit", maxRecords: 3, messages: 4, wantErr: ErrSpoolFull, wantFull: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spool := newTestSpool(t, t.TempDir(), record*2, record*tt.maxRecords)
+			defer spool.Close()
+			var err error
+			for i := 0; i < tt.messages && err == nil; i++ {
+				err = spool.Append(spoolTestMessage(i))
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Append error = %v, want %v", err, tt.wantErr)
+			}
+			if got := spool.Full(); got != tt.wantFull {
+				t.Errorf("Full = %v, want %v", got, tt.wantFull)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+// Errors
+var (
+	ErrInvalidToken = errors.New("invalid tracking token")
+	ErrTokenExpired = errors.New("tracking token expired")
+)
+
+/*
+ TokenSigner signs the tracking tokens of the pixel and click URLs: base64url(json payload) + "." + base64url(HMAC-SHA256).
+ Without APP_TRACKING_SECRET a random secret is generated at startup, tokens then break on restart and between instances,
+ so production has to set it.
+*/
+
+type TokenSigner struct {
+	log           *zap.SugaredLogger
+	secret        []byte
+	ttl           time.Duration
+	conversionTTL time.Duration
+}
+
+func NewTokenSigner(log *zap.SugaredLogger, cfg *config.Config) *TokenSigner {
+	secret := []byte(cfg.Tracking.Secret)
+	if len(secret) == 0 {
+		log.Warn("APP_TRACKING_SECRET is not set, tracking URLs are signed with a random secret and won't survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalw("Failed to generate tracking secret", "error", err)
+		}
+	}
+	return &TokenSigner{
+		log:           log,
+		secret:        secret,
+		ttl:           cfg.Tracking.TokenTTL,
+		conversionTTL: cfg.Tracking.ConversionTokenTTL,
+	}
+}
+
+// Sign sets the expiry of the token and returns its signed form
+func (s *TokenSigner) Sign(token model.TrackingToken) string {
+	return s.sign(token, s.ttl)
+}
+
+// SignConversion turns the token of a click into the conversion token handed to the landing page, it lives
+// APP_TRACKING_CONVERSION_TOKEN_TTL as conversions come days after the click. Conversions have no price
+func (s *TokenSigner) SignConversion(token model.TrackingToken) string {
+	token.EventType = model.TrackingEventTypeConversion
+	token.Price = 0
+	return s.sign(token, s.conversionTTL)
+}
+
+func (s *TokenSigner) sign(token model.TrackingToken, ttl time.Duration) string {
+	token.ExpiresAt = time.Now().Add(ttl).Unix()
+	// struct of strings and numbers, can't fail
+	payload, _ := json.Marshal(token)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded))
+}
+
+// Verify checks the signature and expiry and returns the payload
+func (s *TokenSigner) Verify(signed string) (*model.TrackingToken, error) {
+	encoded, signature, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(encoded)) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var token model.TrackingToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() > token.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &token, nil
+}
+
+func (s *TokenSigner) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+)
+
+func newTestSigner(secret string, ttl time.Duration) *TokenSigner {
+	return NewTokenSigner(zap.NewNop().Sugar(), &config.Config{Tracking: config.TrackingConfig{Secret: secret, TokenTTL: ttl, ConversionTokenTTL: 30 * 24 * time.Hour}})
+}
+
+func TestTokenSigner(t *testing.T) {
+	signer := newTestSigner("test-secret", time.Hour)
+	token := model.TrackingToken{AuctionID: "5f1c8a4e-6b0d-4c59-9d3e-2a7b1c0f9e11", LineItemID: "li-1", Placement: "homepage_top", Price: 2.5, UserID: "user-1"}
+	signed := signer.Sign(token)
+	encoded, signature, _ := strings.Cut(signed, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"a":"5f1c8a4e-6b0d-4c59-9d3e-2a7b1c0f9e11","l":"li-1","p":"homepage_top","c":0.01,"x":9999999999}`))
+
+	tests := []struct {
+		name    string
+		signed  string
+		wantErr error
+	}{
+		{name: "valid", signed: signed},
+		{name: "empty", signed: "", wantErr: ErrInvalidToken},
+		{name: "no signature", signed: encoded, wantErr: ErrInvalidToken},
+		{name: "tampered payload", signed: forged + "." + signature, wantErr: ErrInvalidToken},
+		{name: "signature of another payload", signed: encoded + "." + base64.RawURLEncoding.EncodeToString(signer.mac(forged)), wantErr: ErrInvalidToken},
+		{name: "signature not base64", signed: encoded + ".!!!", wantErr: ErrInvalidToken},
+		{name: "other secret", signed: newTestSigner("other-secret", time.Hour).Sign(token), wantErr: ErrInvalidToken},
+		{name: "expired", signed: newTestSigner("test-secret", -time.Second).Sign(token), wantErr: ErrTokenExpired},
+		{name: "payload not json", signed: "bm90IGpzb24." + base64.RawURLEncoding.EncodeToString(signer.mac("bm90IGpzb24")), wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := signer.Verify(tt.signed)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got.ExpiresAt = 0
+			if *got != token {
+				t.Errorf("Verify = %+v, want %+v", *got, token)
+			}
+		})
+	}
+}
+
+func TestTokenSignerConversion(t *testing.T) {
+	signer := newTestSigner("test-secret", time.Minute)
+	click := model.TrackingToken{AuctionID: "5f1c8a4e-6b0d-4c59-9d3e-2a7b1c0f9e11", LineItemID: "li-1", Placement: "homepage_top", Price: 2.5}
+	token, err := signer.Verify(signer.SignConversion(click))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.EventType != model.TrackingEventTypeConversion || token.Price != 0 || token.LineItemID != click.LineItemID {
+		t.Errorf("conversion token %+v, want a conversion of %s without price", token, click.LineItemID)
+	}
+	// conversion tokens live APP_TRACKING_CONVERSION_TOKEN_TTL, not the pixel TTL
+	if lifetime := time.Until(time.Unix(token.ExpiresAt, 0)); lifetime < 29*24*time.Hour {
+		t.Errorf("conversion token valid for %s", lifetime)
+	}
+}
+
+func TestBannerMarkup(t *testing.T) {
+	cfg := testAdConfig(t)
+	cfg.Server.PublicURL = "https://ads.example/"
+	s, items := newTestAdService(t, cfg, testLineItem(`Shoes "50% off" <sale>`, "adv-1", 2))
+	token := model.TrackingToken{AuctionID: "5f1c8a4e-6b0d-4c59-9d3e-2a7b1c0f9e11", LineItemID: items[0].ID, Placement: "homepage_top", Price: 2}
+	impressionURL, clickURL := s.trackingPixelURLs(token)
+	ad := &model.Ad{ID: items[0].ID, Name: items[0].Name, ServeURL: serveURL(items[0].ID), ImpressionURL: impressionURL, ClickURL: clickURL}
+
+	for _, trackingURL := range []string{impressionURL, clickURL} {
+		parsed, err := url.Parse(trackingURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Host != "ads.example" {
+			t.Errorf("%s not on the public URL", trackingURL)
+		}
+		if got, err := s.signer.Verify(parsed.Query().Get("t")); err != nil || got.LineItemID != items[0].ID || got.Price != 2 {
+			t.Errorf("%s: token %+v, error %v", trackingURL, got, err)
+		}
+	}
+
+	want := `<div style="position:relative;width:300px;height:250px">` +
+		`<iframe src="https://content.realtimemediatool.com/data/` + items[0].ID + `" width="300" height="250" title="Shoes &#34;50% off&#34; &lt;sale&gt;" frameborder="0" marginwidth="0" marginheight="0" scrolling="no"></iframe>` +
+		`<a href="https://ads.example/t/click?t=TOKEN" target="_blank" rel="noopener" style="position:absolute;top:0;left:0;width:100%;height:100%"></a>` +
+		`<img src="https://ads.example/t/imp.gif?t=TOKEN" width="1" height="1" alt="" style="position:absolute;border:0">` +
+		`</div>`
+	got := bannerMarkup(ad, 300, 250)
+	got = strings.NewReplacer(strings.TrimPrefix(impressionURL, "https://ads.example/t/imp.gif?t="), "TOKEN").Replace(got)
+	if got != want {
+		t.Errorf("bannerMarkup\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+)
+
+/*
+ Tracking messages are JSON by default, APP_PUB_SUB_ENCODING=protobuf switches to the wire format of
+ api/tracking_event.proto (written by hand with protowire, there is no generated code to keep in sync).
+ Every message carries headers telling consumers how to read it: schema_version, content_type and schema, and
+ event_type so they can skip what they don't need without decoding. trace_id ties it to the request that sent the event.
+ The key is the line item (or the user, APP_PUB_SUB_MESSAGE_KEY), see partitioner.go.
+ The ClickHouse pipeline in schema.sql reads JSON, protobuf is for consumers that want it smaller.
+*/
+
+// Message encodings, selected with APP_PUB_SUB_ENCODING
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	trackingSchemaName  = "tracking.v2.TrackingEvent"
+)
+
+// Kafka headers of every tracking message
+const (
+	HeaderSchemaVersion = "schema_version"
+	HeaderContentType   = "content_type"
+	HeaderSchema        = "schema"
+	HeaderEventType     = "event_type"
+	HeaderTraceID       = "trace_id"
+)
+
+// Message keys, selected with APP_PUB_SUB_MESSAGE_KEY
+const (
+	MessageKeyLineItem = "line_item"
+	MessageKeyUser     = "user"
+	MessageKeyNone     = "none"
+)
+
+// NewTrackingMessage builds the message of a tracking event, price is the clearing price of the served ad and context
+// the auction that served it (may be nil)
+func NewTrackingMessage(event model.TrackingEvent, price float64, context *model.AuctionContext) model.TrackingMessage {
+	now := time.Now()
+	message := model.TrackingMessage{
+		SchemaVersion: model.TrackingSchemaVersion,
+		EventID:       event.EventID,
+		EventTime:     now,
+		EventMinute:   now.Minute(),
+		EventType:     event.EventType,
+		LineItemID:    event.LineItemID,
+		AuctionID:     event.AuctionID,
+		UserID:        event.UserID,
+		Placement:     event.Placement,
+		Price:         price,
+		ReportedPrice: event.Price,
+		ExperimentID:  event.ExperimentID,
+		VariantID:     event.VariantID,
+		ErrorCode:     event.ErrorCode,
+		Metadata:      event.Metadata,
+		Auction:       context,
+	}
+	switch event.EventType {
+	case model.TrackingEventTypeImpression:
+		message.Impressions = 1
+	case model.TrackingEventTypeClick:
+		message.Clicks = 1
+	case model.TrackingEventTypeConversion:
+		message.Conversions = 1
+	}
+	if !event.Timestamp.IsZero() {
+		clientTime := event.Timestamp
+		message.ClientTime = &clientTime
+	}
+	if context != nil && len(context.Keywords) > 0 {
+		message.Keyword = context.Keywords[0]
+	}
+	return message
+}
+
+// EncodeTrackingMessage serializes the message in APP_PUB_SUB_ENCODING with its key and headers, anything but protobuf is JSON
+func EncodeTrackingMessage(message model.TrackingMessage, cfg *config.Config) Message {
+	headers := map[string]string{
+		HeaderSchemaVersion: strconv.Itoa(message.SchemaVersion),
+		HeaderSchema:        trackingSchemaName,
+		HeaderEventType:     string(message.EventType),
+	}
+	if message.TraceID != "" {
+		headers[HeaderTraceID] = message.TraceID
+	}
+	key := ""
+	switch cfg.PubSub.MessageKey {
+	case MessageKeyLineItem:
+		key = message.LineItemID
+	case MessageKeyUser:
+		key = message.UserID
+	}
+	if cfg.PubSub.Encoding == EncodingProtobuf {
+		headers[HeaderContentType] = ContentTypeProtobuf
+		return Message{Key: key, Value: marshalTrackingProto(message), Headers: headers}
+	}
+	headers[HeaderContentType] = ContentTypeJSON
+	// nothing in the message can fail to encode
+	value, _ := json.Marshal(message)
+	return Message{Key: key, Value: value, Headers: headers}
+}
+
+// marshalTrackingProto writes tracking.v2.TrackingEvent, field numbers have to match api/tracking_event.proto.
+// Zero values are left out like proto3 does
+func marshalTrackingProto(m model.TrackingMessage) []byte {
+	b := []byte{}
+	b = appendProtoVarint(b, 1, uint64(m.SchemaVersion))
+	b = appendProtoString(b, 2, m.EventID)
+	b = appendProtoVarint(b, 3, uint64(m.EventTime.UnixNano()))
+	b = appendProtoVarint(b, 4, uint64(m.EventMinute))
+	b = appendProtoString(b, 5, string(m.EventType))
+	b = appendProtoString(b, 6, m.LineItemID)
+	b = appendProtoString(b, 7, m.AuctionID)
+	b = appendProtoString(b, 8, m.UserID)
+	b = appendProtoString(b, 9, m.Placement)
+	b = appendProtoString(b, 10, m.Keyword)
+	b = appendProtoVarint(b, 11, uint64(m.Clicks))
+	b = appendProtoVarint(b, 12, uint64(m.Impressions))
+	b = appendProtoVarint(b, 13, uint64(m.Conversions))
+	b = appendProtoDouble(b, 14, m.Price)
+	b = appendProtoString(b, 15, m.ExperimentID)
+	b = appendProtoString(b, 16, m.VariantID)
+	b = appendProtoString(b, 17, m.ErrorCode)
+	if m.ClientTime != nil {
+		b = appendProtoVarint(b, 18, uint64(m.ClientTime.UnixNano()))
+	}
+	// map entries are messages of key = 1 and value = 2, sorted so the same event always encodes the same
+	keys := make([]string, 0, len(m.Metadata))
+	for key := range m.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := appendProtoString(nil, 1, key)
+		entry = appendProtoString(entry, 2, m.Metadata[key])
+		b = appendProtoMessage(b, 19, entry)
+	}
+	if m.Auction != nil {
+		b = appendProtoMessage(b, 20, marshalAuctionContextProto(*m.Auction))
+	}
+	b = appendProtoDouble(b, 21, m.ReportedPrice)
+	return b
+}
+
+func marshalAuctionContextProto(c model.AuctionContext) []byte {
+	b := []byte{}
+	b = appendProtoVarint(b, 1, uint64(c.ServedAt.UnixNano()))
+	b = appendProtoVarint(b, 2, uint64(c.Position))
+	b = appendProtoDouble(b, 3, c.Price)
+	for _, keyword := range c.Keywords {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, keyword)
+	}
+	for _, category := range c.Categories {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, category)
+	}
+	b = appendProtoString(b, 6, c.Country)
+	b = appendProtoString(b, 7, c.Region)
+	b = appendProtoString(b, 8, c.DeviceType)
+	b = appendProtoString(b, 9, c.RankingMode)
+	if c.Explored {
+		b = appendProtoVarint(b, 10, 1)
+	}
+	return b
+}
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
@@ -0,0 +1,109 @@
+package service
+
+/*
+ KeywordTrie sits next to the exact keyword map in RunTimeDB. Exact lookups still go through the map (O(1)),
+ trie is only walked for partial matching: prefix ("electro" -> "electronic") and bounded edit distance ("electonic" -> "electronic").
+ Edit distance search walks the trie once and keeps one Levenshtein row per node, so shared prefixes are computed only once
+ and whole branches are cut as soon as the row minimum goes over the allowed distance.
+*/
+
+type trieNode struct {
+	children map[rune]*trieNode
+	term     string
+	terminal bool
+}
+
+type KeywordTrie struct {
+	root *trieNode
+}
+
+// FuzzyMatch is an indexed term within the allowed edit distance of the query
+type FuzzyMatch struct {
+	Term     string
+	Distance int
+}
+
+func NewKeywordTrie() *KeywordTrie {
+	return &KeywordTrie{root: &trieNode{children: map[rune]*trieNode{}}}
+}
+
+func (t *KeywordTrie) Insert(term string) {
+	node := t.root
+	for _, r := range term {
+		child, ok := node.children[r]
+		if !ok {
+			child = &trieNode{children: map[rune]*trieNode{}}
+			node.children[r] = child
+		}
+		node = child
+	}
+	node.terminal = true
+	node.term = term
+}
+
+// WithPrefix returns every indexed term starting with prefix, the prefix itself is left out because that is an exact match
+func (t *KeywordTrie) WithPrefix(prefix string) []string {
+	node := t.root
+	for _, r := range prefix {
+		child, ok := node.children[r]
+		if !ok {
+			return []string{}
+		}
+		node = child
+	}
+	result := []string{}
+	var collect func(n *trieNode)
+	collect = func(n *trieNode) {
+		if n.terminal && n.term != prefix {
+			result = append(result, n.term)
+		}
+		for _, child := range n.children {
+			collect(child)
+		}
+	}
+	collect(node)
+	return result
+}
+
+// WithinDistance returns every indexed term whose Levenshtein distance to term is between 1 and maxDistance
+func (t *KeywordTrie) WithinDistance(term string, maxDistance int) []FuzzyMatch {
+	result := []FuzzyMatch{}
+	if maxDistance <= 0 {
+		return result
+	}
+	word := []rune(term)
+	firstRow := make([]int, len(word)+1)
+	for i := range firstRow {
+		firstRow[i] = i
+	}
+	var search func(n *trieNode, r rune, previousRow []int)
+	search = func(n *trieNode, r rune, previousRow []int) {
+		row := make([]int, len(word)+1)
+		row[0] = previousRow[0] + 1
+		rowMin := row[0]
+		for i := 1; i <= len(word); i++ {
+			substitution := previousRow[i-1]
+			if word[i-1] != r {
+				substitution++
+			}
+			row[i] = min(row[i-1]+1, previousRow[i]+1, substitution)
+			rowMin = min(rowMin, row[i])
+		}
+		if n.terminal {
+			if distance := row[len(word)]; distance > 0 && distance <= maxDistance {
+				result = append(result, FuzzyMatch{Term: n.term, Distance: distance})
+			}
+		}
+		// nothing below this node can get closer than the best cell of this row
+		if rowMin > maxDistance {
+			return
+		}
+		for childRune, child := range n.children {
+			search(child, childRune, row)
+		}
+	}
+	for r, child := range t.root.children {
+		search(child, r, firstRow)
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+var trieTerms = []string{"electronic", "electronics", "electric", "elect", "game", "gaming", "games", "shoe", "café"}
+
+func newTestTrie() *KeywordTrie {
+	trie := NewKeywordTrie()
+	for _, term := range trieTerms {
+		trie.Insert(term)
+	}
+	return trie
+}
+
+func TestKeywordTrieWithPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "electro", want: []string{"electronic", "electronics"}},
+		{prefix: "elect", want: []string{"electric", "electronic", "electronics"}},
+		{prefix: "gam", want: []string{"game", "games", "gaming"}},
+		{prefix: "shoe", want: []string{}},
+		{prefix: "caf", want: []string{"café"}},
+		{prefix: "x", want: []string{}},
+	}
+	trie := newTestTrie()
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got := trie.WithPrefix(tt.prefix)
+			sort.Strings(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("WithPrefix(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeywordTrieWithinDistance(t *testing.T) {
+	tests := []struct {
+		term        string
+		maxDistance int
+		want        map[string]int
+	}{
+		{term: "electonic", maxDistance: 1, want: map[string]int{"electronic": 1}},
+		{term: "electonic", maxDistance: 2, want: map[string]int{"electronic": 1, "electronics": 2, "electric": 2}},
+		{term: "gaem", maxDistance: 2, want: map[string]int{"game": 2, "games": 2}},
+		{term: "game", maxDistance: 1, want: map[string]int{"games": 1}},
+		{term: "shoe", maxDistance: 0, want: map[string]int{}},
+		{term: "cafe", maxDistance: 1, want: map[string]int{"café": 1}},
+		{term: "zzzz", maxDistance: 2, want: map[string]int{}},
+	}
+	trie := newTestTrie()
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			got := map[string]int{}
+			for _, match := range trie.WithinDistance(tt.term, tt.maxDistance) {
+				got[match.Term] = match.Distance
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("WithinDistance(%q, %d) = %v, want %v", tt.term, tt.maxDistance, got, tt.want)
+			}
+			for term, distance := range tt.want {
+				if got[term] != distance {
+					t.Errorf("WithinDistance(%q, %d) = %v, want %v", tt.term, tt.maxDistance, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// the pruned trie walk has to find exactly what comparing the term with every indexed term finds
+func TestKeywordTrieWithinDistanceBounds(t *testing.T) {
+	trie := newTestTrie()
+	for _, query := range []string{"electronix", "elec", "gamin", "sho", "games", "electrics", "xyz"} {
+		for maxDistance := 1; maxDistance <= 3; maxDistance++ {
+			got := map[string]int{}
+			for _, match := range trie.WithinDistance(query, maxDistance) {
+				got[match.Term] = match.Distance
+			}
+			for _, term := range trieTerms {
+				distance := levenshtein(query, term)
+				want := distance > 0 && distance <= maxDistance
+				if gotDistance, ok := got[term]; ok != want || (ok && gotDistance != distance) {
+					t.Errorf("WithinDistance(%q, %d): %s got %d (found %v), distance %d", query, maxDistance, term, gotDistance, ok, distance)
+				}
+			}
+		}
+	}
+}
+
+// levenshtein is the plain dynamic programming distance the trie walk is checked against
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		previous := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			substitution := previous
+			if ra[i-1] != rb[j-1] {
+				substitution++
+			}
+			previous = row[j]
+			row[j] = min(row[j]+1, row[j-1]+1, substitution)
+		}
+	}
+	return row[len(rb)]
+}
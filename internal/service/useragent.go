@@ -0,0 +1,86 @@
+package service
+
+import (
+	"strings"
+
+	"sweng-task/internal/model"
+)
+
+/*
+ Deliberately small User-Agent parser, we only need device type, OS family and browser family for targeting.
+ Order of the checks matters a lot: every Chromium browser also says "Chrome" and "Safari", Android tablets are
+ Android without "Mobile", iPadOS 13+ pretends to be a Mac (we can't tell it apart without client hints).
+*/
+
+func ParseUserAgent(userAgent string) model.Device {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return model.Device{}
+	}
+	return model.Device{
+		Type:    parseDeviceType(ua),
+		OS:      parseOS(ua),
+		Browser: parseBrowser(ua),
+	}
+}
+
+func parseDeviceType(ua string) string {
+	switch {
+	case containsAny(ua, "bot", "crawler", "spider", "slurp"):
+		return model.DeviceTypeBot
+	case containsAny(ua, "smart-tv", "smarttv", "appletv", "roku", "crkey", "tizen", "webos", "bravia"):
+		return model.DeviceTypeCTV
+	case containsAny(ua, "ipad", "tablet", "kindle", "silk/") || (strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return model.DeviceTypeTablet
+	case containsAny(ua, "mobi", "iphone", "ipod", "windows phone"):
+		return model.DeviceTypeMobile
+	}
+	return model.DeviceTypeDesktop
+}
+
+func parseOS(ua string) string {
+	switch {
+	case containsAny(ua, "iphone", "ipad", "ipod"):
+		return model.DeviceOSIOS
+	case strings.Contains(ua, "android"):
+		return model.DeviceOSAndroid
+	case strings.Contains(ua, "windows"):
+		return model.DeviceOSWindows
+	case strings.Contains(ua, "cros "):
+		return model.DeviceOSChromeOS
+	case containsAny(ua, "mac os x", "macintosh"):
+		return model.DeviceOSMacOS
+	case strings.Contains(ua, "linux"):
+		return model.DeviceOSLinux
+	}
+	return model.DeviceOther
+}
+
+func parseBrowser(ua string) string {
+	switch {
+	case containsAny(ua, "edg/", "edga/", "edgios/", "edge/"):
+		return model.BrowserEdge
+	case containsAny(ua, "opr/", "opera"):
+		return model.BrowserOpera
+	case strings.Contains(ua, "samsungbrowser"):
+		return model.BrowserSamsung
+	case containsAny(ua, "chrome/", "crios/", "chromium/"):
+		return model.BrowserChrome
+	case containsAny(ua, "firefox/", "fxios/"):
+		return model.BrowserFirefox
+	case containsAny(ua, "msie", "trident/"):
+		return model.BrowserIE
+	case strings.Contains(ua, "safari/") && strings.Contains(ua, "version/"):
+		return model.BrowserSafari
+	}
+	return model.DeviceOther
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      model.Device
+	}{
+		{
+			name:      "chrome on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOSWindows, Browser: model.BrowserChrome},
+		},
+		{
+			name:      "edge on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOSWindows, Browser: model.BrowserEdge},
+		},
+		{
+			name:      "opera on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 OPR/109.0.0.0",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOSWindows, Browser: model.BrowserOpera},
+		},
+		{
+			name:      "safari on mac",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOSMacOS, Browser: model.BrowserSafari},
+		},
+		{
+			name:      "firefox on linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOSLinux, Browser: model.BrowserFirefox},
+		},
+		{
+			name:      "chrome on chromebook",
+			userAgent: "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOSChromeOS, Browser: model.BrowserChrome},
+		},
+		{
+			name:      "internet explorer",
+			userAgent: "Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOSWindows, Browser: model.BrowserIE},
+		},
+		{
+			name:      "safari on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want:      model.Device{Type: model.DeviceTypeMobile, OS: model.DeviceOSIOS, Browser: model.BrowserSafari},
+		},
+		{
+			name:      "chrome on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			want:      model.Device{Type: model.DeviceTypeMobile, OS: model.DeviceOSIOS, Browser: model.BrowserChrome},
+		},
+		{
+			name:      "chrome on android phone",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36",
+			want:      model.Device{Type: model.DeviceTypeMobile, OS: model.DeviceOSAndroid, Browser: model.BrowserChrome},
+		},
+		{
+			name:      "samsung browser",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; SM-S921B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			want:      model.Device{Type: model.DeviceTypeMobile, OS: model.DeviceOSAndroid, Browser: model.BrowserSamsung},
+		},
+		{
+			name:      "android tablet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      model.Device{Type: model.DeviceTypeTablet, OS: model.DeviceOSAndroid, Browser: model.BrowserChrome},
+		},
+		{
+			name:      "ipad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			want:      model.Device{Type: model.DeviceTypeTablet, OS: model.DeviceOSIOS, Browser: model.BrowserSafari},
+		},
+		{
+			name:      "smart tv",
+			userAgent: "Mozilla/5.0 (SMART-TV; Linux; Tizen 7.0) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/5.0 Chrome/94.0.4606.31 TV Safari/537.36",
+			want:      model.Device{Type: model.DeviceTypeCTV, OS: model.DeviceOSLinux, Browser: model.BrowserSamsung},
+		},
+		{
+			name:      "bot",
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want:      model.Device{Type: model.DeviceTypeBot, OS: model.DeviceOther, Browser: model.DeviceOther},
+		},
+		{
+			name:      "curl",
+			userAgent: "curl/8.5.0",
+			want:      model.Device{Type: model.DeviceTypeDesktop, OS: model.DeviceOther, Browser: model.DeviceOther},
+		},
+		{
+			name:      "empty",
+			userAgent: "",
+			want:      model.Device{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserAgent(tt.userAgent); got != tt.want {
+				t.Errorf("ParseUserAgent = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetingIndex(t *testing.T) {
+	index := NewTargetingIndex()
+	index.Add([]string{model.DeviceTypeMobile, model.DeviceTypeTablet}, "li-mobile")
+	index.Add([]string{model.DeviceTypeDesktop}, "li-desktop")
+	index.Add(nil, "li-any")
+
+	tests := []struct {
+		name        string
+		value       string
+		wantBlocked []string
+	}{
+		{name: "mobile", value: model.DeviceTypeMobile, wantBlocked: []string{"li-desktop"}},
+		{name: "desktop", value: model.DeviceTypeDesktop, wantBlocked: []string{"li-mobile"}},
+		{name: "ctv", value: model.DeviceTypeCTV, wantBlocked: []string{"li-mobile", "li-desktop"}},
+		// no User-Agent, only untargeted line items can serve
+		{name: "unknown", value: "", wantBlocked: []string{"li-mobile", "li-desktop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed := index.Allowed(tt.value)
+			blocked := map[string]bool{}
+			for _, id := range tt.wantBlocked {
+				blocked[id] = true
+			}
+			for _, id := range []string{"li-mobile", "li-desktop", "li-any"} {
+				if got := index.Blocks(id, allowed); got != blocked[id] {
+					t.Errorf("Blocks(%s) = %v, want %v", id, got, blocked[id])
+				}
+			}
+		})
+	}
+}
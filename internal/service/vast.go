@@ -0,0 +1,158 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sweng-task/internal/model"
+
+	"github.com/google/uuid"
+)
+
+/*
+ VAST renders the winners of a video auction for video players. Line items with media files are written as InLine ads,
+ line items with only a third party VAST tag as Wrapper ads. Either way impression, quartile, click and error URLs
+ are signed like the pixel: the impression is the pixel itself, the others go to GET /t/event with the event type in
+ the token, so the player can't change the line item, the auction or the price. Video events land in the same stream as everything else.
+ The Error URL carries the [ERRORCODE] macro, the player replaces it with the VAST error code.
+*/
+
+const (
+	vastVersion = "4.2"
+	// tracking events need a user, players of anonymous viewers don't send one
+	anonymousUserID = "anonymous"
+)
+
+// VAST tracking events and the tracking event types they are recorded as
+var vastTrackingEvents = []struct {
+	event     string
+	eventType model.TrackingEventType
+}{
+	{"start", model.TrackingEventTypeStart},
+	{"firstQuartile", model.TrackingEventTypeFirstQuartile},
+	{"midpoint", model.TrackingEventTypeMidpoint},
+	{"thirdQuartile", model.TrackingEventTypeThirdQuartile},
+	{"complete", model.TrackingEventTypeComplete},
+	{"skip", model.TrackingEventTypeSkip},
+}
+
+// GetVAST runs the auction with video line items only and renders the winners, no winner is an empty VAST
+func (s *AdService) GetVAST(query model.WinningAdsQuery) *model.VAST {
+	query.Video = true
+	ads, _ := s.auction(query, nil, nil)
+	return s.renderVAST(ads, query.UserID)
+}
+
+func (s *AdService) renderVAST(ads []*model.Ad, userID string) *model.VAST {
+	if userID == "" {
+		userID = anonymousUserID
+	}
+	vast := &model.VAST{Version: vastVersion, Ads: []model.VASTAd{}}
+	servingID := uuid.New().String()
+	for i, ad := range ads {
+		creative := s.lis.items[ad.ID].Creative
+		track := func(eventType model.TrackingEventType) string {
+			return s.eventURL(eventType, ad, userID)
+		}
+		errors := []model.VASTURL{{URL: track(model.TrackingEventTypeError) + "&error_code=[ERRORCODE]"}}
+		// signed pixel, the player can't change line item or price of the paid event
+		impressions := []model.VASTURL{{ID: s.cfg.App.Name, URL: ad.ImpressionURL}}
+		linear := model.VASTLinear{
+			VideoClicks: &model.VASTVideoClicks{ClickTrackings: []model.VASTURL{{URL: track(model.TrackingEventTypeClick)}}},
+		}
+		for _, tracking := range vastTrackingEvents {
+			linear.TrackingEvents = append(linear.TrackingEvents, model.VASTTracking{Event: tracking.event, URL: track(tracking.eventType)})
+		}
+
+		vastAd := model.VASTAd{ID: ad.ID}
+		// ads of a pod are played in sequence, a single ad has no sequence
+		if len(ads) > 1 {
+			vastAd.Sequence = i + 1
+		}
+		if len(creative.MediaFiles) == 0 {
+			vastAd.Wrapper = &model.VASTWrapper{
+				FollowAdditionalWrappers: true,
+				AllowMultipleAds:         false,
+				FallbackOnNoAd:           true,
+				AdSystem:                 s.cfg.App.Name,
+				Errors:                   errors,
+				Impressions:              impressions,
+				VASTAdTagURI:             model.VASTURL{URL: creative.VASTTagURL},
+				Creatives:                []model.VASTCreative{{Linear: linear}},
+			}
+			vast.Ads = append(vast.Ads, vastAd)
+			continue
+		}
+
+		linear.Duration = vastDuration(creative.Duration)
+		if creative.ClickThroughURL != "" {
+			linear.VideoClicks.ClickThrough = &model.VASTURL{URL: creative.ClickThroughURL}
+		}
+		linear.MediaFiles = &model.VASTMediaFiles{}
+		for _, media := range creative.MediaFiles {
+			delivery := media.Delivery
+			if delivery == "" {
+				delivery = "progressive"
+			}
+			linear.MediaFiles.MediaFiles = append(linear.MediaFiles.MediaFiles, model.VASTMediaFile{
+				Delivery: delivery,
+				Type:     media.MimeType,
+				Width:    media.Width,
+				Height:   media.Height,
+				Bitrate:  media.Bitrate,
+				URL:      media.URL,
+			})
+		}
+		vastAd.InLine = &model.VASTInLine{
+			AdSystem:    s.cfg.App.Name,
+			AdServingID: servingID,
+			AdTitle:     ad.Name,
+			Errors:      errors,
+			Impressions: impressions,
+			Pricing:     &model.VASTPricing{Model: "CPM", Currency: openRTBCurrency, Value: strconv.FormatFloat(ad.Price, 'f', -1, 64)},
+			Creatives: []model.VASTCreative{{
+				ID:            ad.ID,
+				UniversalAdID: &model.VASTUniversalAdID{IDRegistry: "unknown", Value: ad.ID},
+				Linear:        linear,
+			}},
+		}
+		vast.Ads = append(vast.Ads, vastAd)
+	}
+	return vast
+}
+
+// MarshalVAST writes the VAST document with the xml header
+func MarshalVAST(vast *model.VAST) ([]byte, error) {
+	body, err := xml.Marshal(vast)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// eventURL is the signed GET /t/event URL recording the event for the served ad
+func (s *AdService) eventURL(eventType model.TrackingEventType, ad *model.Ad, userID string) string {
+	signed := s.signer.Sign(model.TrackingToken{
+		AuctionID:    ad.AuctionID,
+		LineItemID:   ad.ID,
+		Placement:    ad.Placement,
+		Price:        ad.Price,
+		UserID:       userID,
+		ExperimentID: ad.ExperimentID,
+		VariantID:    ad.VariantID,
+		EventType:    eventType,
+	})
+	return strings.TrimRight(s.cfg.Server.PublicURL, "/") + "/t/event?t=" + url.QueryEscape(signed)
+}
+
+func hasVideoCreative(item *model.LineItem) bool {
+	return item.Creative != nil && (len(item.Creative.MediaFiles) > 0 || item.Creative.VASTTagURL != "")
+}
+
+// vastDuration formats seconds as HH:MM:SS.mmm
+func vastDuration(seconds int) string {
+	return fmt.Sprintf("%02d:%02d:%02d.000", seconds/3600, seconds/60%60, seconds%60)
+}
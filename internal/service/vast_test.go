@@ -0,0 +1,213 @@
+package service
+
+import (
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+// tokens expire and serving ids are random, the golden documents have placeholders instead
+var (
+	vastTokenPattern     = regexp.MustCompile(`t=[A-Za-z0-9_%.-]+`)
+	vastServingIDPattern = regexp.MustCompile(`<AdServingId>[^<]+</AdServingId>`)
+	vastIndent           = regexp.MustCompile(`\n\s*`)
+)
+
+func TestRenderVAST(t *testing.T) {
+	inline := testLineItem("Running shoes", "adv-1", 4)
+	inline.Placement = "video_preroll"
+	inline.Creative = &model.Creative{
+		MediaFiles: []model.MediaFile{
+			{URL: "https://cdn.example/shoes.mp4", MimeType: "video/mp4", Width: 1280, Height: 720, Bitrate: 2000},
+			{URL: "https://cdn.example/shoes.m3u8", MimeType: "application/x-mpegURL", Width: 1920, Height: 1080, Delivery: "streaming"},
+		},
+		Duration:        75,
+		ClickThroughURL: "https://advertiser.example/shoes?a=1&b=2",
+	}
+	wrapper := testLineItem("Third party", "adv-2", 3)
+	wrapper.Placement = "video_preroll"
+	wrapper.Creative = &model.Creative{VASTTagURL: "https://adserver.example/vast?id=42"}
+	s, items := newTestAdService(t, testAdConfig(t), inline, wrapper)
+
+	const testAuctionID = "5f1c8a4e-6b0d-4c59-9d3e-2a7b1c0f9e11"
+	served := func(item *model.LineItem, price float64) *model.Ad {
+		pixel := s.signer.Sign(model.TrackingToken{AuctionID: testAuctionID, LineItemID: item.ID, Placement: item.Placement, Price: price, UserID: "user-1"})
+		return &model.Ad{
+			ID:            item.ID,
+			AuctionID:     testAuctionID,
+			Name:          item.Name,
+			Price:         price,
+			Placement:     item.Placement,
+			ImpressionURL: "http://localhost:8080/t/imp.gif?t=" + url.QueryEscape(pixel),
+		}
+	}
+	inlineAd, wrapperAd := served(items[0], 3.5), served(items[1], 2.25)
+
+	tests := []struct {
+		name string
+		ads  []*model.Ad
+		// golden document, indentation is only for reading, it's removed before the comparison
+		want string
+	}{
+		{
+			name: "empty",
+			want: `<VAST version="4.2"></VAST>`,
+		},
+		{
+			name: "inline",
+			ads:  []*model.Ad{inlineAd},
+			want: `<VAST version="4.2">
+			<Ad id="li-inline">
+				<InLine>
+					<AdSystem>Ad Bidding Service</AdSystem>
+					<AdServingId>SERVING_ID</AdServingId>
+					<AdTitle>Running shoes</AdTitle>
+					<Error><![CDATA[http://localhost:8080/t/event?t=TOKEN&error_code=[ERRORCODE]]]></Error>
+					<Impression id="Ad Bidding Service"><![CDATA[http://localhost:8080/t/imp.gif?t=TOKEN]]></Impression>
+					<Pricing model="CPM" currency="USD">3.5</Pricing>
+					<Creatives><Creative id="li-inline">
+						<UniversalAdId idRegistry="unknown">li-inline</UniversalAdId>
+						<Linear>
+							<Duration>00:01:15.000</Duration>
+							<TrackingEvents>
+								<Tracking event="start"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="firstQuartile"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="midpoint"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="thirdQuartile"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="complete"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="skip"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+							</TrackingEvents>
+							<VideoClicks>
+								<ClickThrough><![CDATA[https://advertiser.example/shoes?a=1&b=2]]></ClickThrough>
+								<ClickTracking><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></ClickTracking>
+							</VideoClicks>
+							<MediaFiles>
+								<MediaFile delivery="progressive" type="video/mp4" width="1280" height="720" bitrate="2000"><![CDATA[https://cdn.example/shoes.mp4]]></MediaFile>
+								<MediaFile delivery="streaming" type="application/x-mpegURL" width="1920" height="1080"><![CDATA[https://cdn.example/shoes.m3u8]]></MediaFile>
+							</MediaFiles>
+						</Linear>
+					</Creative></Creatives>
+				</InLine>
+			</Ad>
+			</VAST>`,
+		},
+		{
+			name: "pod",
+			ads:  []*model.Ad{inlineAd, wrapperAd},
+			want: `<VAST version="4.2">
+			<Ad id="li-inline" sequence="1">
+				<InLine>
+					<AdSystem>Ad Bidding Service</AdSystem>
+					<AdServingId>SERVING_ID</AdServingId>
+					<AdTitle>Running shoes</AdTitle>
+					<Error><![CDATA[http://localhost:8080/t/event?t=TOKEN&error_code=[ERRORCODE]]]></Error>
+					<Impression id="Ad Bidding Service"><![CDATA[http://localhost:8080/t/imp.gif?t=TOKEN]]></Impression>
+					<Pricing model="CPM" currency="USD">3.5</Pricing>
+					<Creatives><Creative id="li-inline">
+						<UniversalAdId idRegistry="unknown">li-inline</UniversalAdId>
+						<Linear>
+							<Duration>00:01:15.000</Duration>
+							<TrackingEvents>
+								<Tracking event="start"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="firstQuartile"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="midpoint"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="thirdQuartile"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="complete"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="skip"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+							</TrackingEvents>
+							<VideoClicks>
+								<ClickThrough><![CDATA[https://advertiser.example/shoes?a=1&b=2]]></ClickThrough>
+								<ClickTracking><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></ClickTracking>
+							</VideoClicks>
+							<MediaFiles>
+								<MediaFile delivery="progressive" type="video/mp4" width="1280" height="720" bitrate="2000"><![CDATA[https://cdn.example/shoes.mp4]]></MediaFile>
+								<MediaFile delivery="streaming" type="application/x-mpegURL" width="1920" height="1080"><![CDATA[https://cdn.example/shoes.m3u8]]></MediaFile>
+							</MediaFiles>
+						</Linear>
+					</Creative></Creatives>
+				</InLine>
+			</Ad>
+			<Ad id="li-wrapper" sequence="2">
+				<Wrapper followAdditionalWrappers="true" allowMultipleAds="false" fallbackOnNoAd="true">
+					<AdSystem>Ad Bidding Service</AdSystem>
+					<Error><![CDATA[http://localhost:8080/t/event?t=TOKEN&error_code=[ERRORCODE]]]></Error>
+					<Impression id="Ad Bidding Service"><![CDATA[http://localhost:8080/t/imp.gif?t=TOKEN]]></Impression>
+					<VASTAdTagURI><![CDATA[https://adserver.example/vast?id=42]]></VASTAdTagURI>
+					<Creatives><Creative>
+						<Linear>
+							<TrackingEvents>
+								<Tracking event="start"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="firstQuartile"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="midpoint"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="thirdQuartile"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="complete"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+								<Tracking event="skip"><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></Tracking>
+							</TrackingEvents>
+							<VideoClicks>
+								<ClickTracking><![CDATA[http://localhost:8080/t/event?t=TOKEN]]></ClickTracking>
+							</VideoClicks>
+						</Linear>
+					</Creative></Creatives>
+				</Wrapper>
+			</Ad>
+			</VAST>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vast := s.renderVAST(tt.ads, "user-1")
+			document, err := MarshalVAST(vast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := vastTokenPattern.ReplaceAllString(string(document), "t=TOKEN")
+			got = vastServingIDPattern.ReplaceAllString(got, "<AdServingId>SERVING_ID</AdServingId>")
+			got = strings.NewReplacer(items[0].ID, "li-inline", items[1].ID, "li-wrapper").Replace(got)
+			if want := xml.Header + vastIndent.ReplaceAllString(tt.want, ""); got != want {
+				t.Errorf("VAST\n%s\nwant\n%s", got, want)
+			}
+
+			// every URL is signed for the served ad: impression, error, click and the tracking events
+			tokens := vastTokenPattern.FindAllString(string(document), -1)
+			if want := len(tt.ads) * (len(vastTrackingEvents) + 3); len(tokens) != want {
+				t.Errorf("%d signed URLs, want %d", len(tokens), want)
+			}
+			for _, match := range tokens {
+				signed, err := url.QueryUnescape(strings.TrimPrefix(match, "t="))
+				if err != nil {
+					t.Fatal(err)
+				}
+				token, err := s.signer.Verify(signed)
+				if err != nil {
+					t.Errorf("token %s: %v", signed, err)
+					continue
+				}
+				if token.AuctionID != testAuctionID || token.UserID != "user-1" {
+					t.Errorf("token %+v, want auction %s and user-1", token, testAuctionID)
+				}
+			}
+			// and records the event it is on
+			for i, ad := range vast.Ads {
+				var creatives []model.VASTCreative
+				if ad.InLine != nil {
+					creatives = ad.InLine.Creatives
+				} else {
+					creatives = ad.Wrapper.Creatives
+				}
+				for j, tracking := range creatives[0].Linear.TrackingEvents {
+					token, err := s.signer.Verify(strings.TrimPrefix(tracking.URL, "http://localhost:8080/t/event?t="))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if token.EventType != vastTrackingEvents[j].eventType || token.LineItemID != tt.ads[i].ID {
+						t.Errorf("%s tracking of %s: token %+v", tracking.Event, tt.ads[i].ID, token)
+					}
+				}
+			}
+		})
+	}
+}
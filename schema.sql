@@ -6,7 +6,7 @@ CREATE TABLE IF NOT EXISTS kafka_ads
     SETTINGS kafka_broker_list = 'kafka:9092',
     kafka_topic_list = 'tracking-events',
     kafka_group_name = 'clickhouse_consumer',
-    kafka_format = 'LineAsString',
+    kafka_format = 'LineAsString', -- JSON tracking messages (APP_PUB_SUB_ENCODING=json), protobuf ones need kafka_format = 'Protobuf' and api/tracking_event.proto
     kafka_poll_timeout_ms = 10000,   -- wait up to 10s before polling
     kafka_max_block_size = 5000;
 
@@ -18,36 +18,142 @@ CREATE TABLE IF NOT EXISTS kafka_ads
 
 CREATE TABLE IF NOT EXISTS ads_final
 (
+    schema_version UInt8, -- version of the tracking message, messages before versioning have 0
+    event_id       String, -- retries are dropped by the bidder within APP_TRACKING_DEDUP_WINDOW, the id is kept to spot the ones that slip through
     event_time     DateTime,
     event_minute   Int64,
     item_id        String,
+    auction_id     String, -- ties the event to the ad response, impressions / served ads per auction is the win-to-impression rate
     user_id        String,
     placement      String,
     keyword        String,
     clicks         UInt32,
     impressions    UInt32,
     conversions    UInt32,
+    price          Float64, -- clearing price (CPM) of the served ad, 0 when the event can't be tied to a served auction. Spend is summed over impressions
+    reported_price Float64, -- price the client sent, never billed, only there to reconcile with the client
+    experiment_id  LowCardinality(String), -- empty when the user was not enrolled in an experiment
+    variant_id     LowCardinality(String),
+    event_type     LowCardinality(String), -- impression, click, conversion or a video event (start, first_quartile, ..., error)
+    error_code     String, -- VAST error code of error events
+    client_time    Nullable(DateTime), -- time reported by the client, for events buffered on the device it can be well before event_time
+    metadata       Map(String, String),
+    -- context of the auction that served the ad, empty when the event has no auction_id or the auction expired
+    served_at      Nullable(DateTime),
+    position       UInt8, -- 1 is the first ad of the response
+    served_price   Float64, -- clearing price (CPM) of the ad in the auction, set on clicks and conversions too
+    keywords       Array(String),
+    categories     Array(LowCardinality(String)),
+    country        LowCardinality(String),
+    region         LowCardinality(String),
+    device_type    LowCardinality(String),
+    ranking_mode   LowCardinality(String),
+    explored       Bool,
+    trace_id       String, -- trace_id kafka header, the traceparent / X-Request-ID of the tracking request
     message        String
 )
     ENGINE = MergeTree()
         PARTITION BY toStartOfWeek(event_time)
         ORDER BY (event_time);
 
+-- Migration of an existing deployment, the file only runs by itself on a fresh volume (docker-entrypoint-initdb.d), run it
+-- again with clickhouse-client --multiquery < schema.sql. CREATE ... IF NOT EXISTS leaves existing tables and views as
+-- they are, the columns added since the first version are added here and the views replaced. On a fresh database it's a no-op.
+-- The Kafka engine only consumes while a view is attached and commits its offsets, dropping the view loses no message.
+ALTER TABLE ads_final
+    ADD COLUMN IF NOT EXISTS schema_version UInt8,
+    ADD COLUMN IF NOT EXISTS event_id       String,
+    ADD COLUMN IF NOT EXISTS auction_id     String,
+    ADD COLUMN IF NOT EXISTS price          Float64,
+    ADD COLUMN IF NOT EXISTS reported_price Float64,
+    ADD COLUMN IF NOT EXISTS experiment_id  LowCardinality(String),
+    ADD COLUMN IF NOT EXISTS variant_id     LowCardinality(String),
+    ADD COLUMN IF NOT EXISTS event_type     LowCardinality(String),
+    ADD COLUMN IF NOT EXISTS error_code     String,
+    ADD COLUMN IF NOT EXISTS client_time    Nullable(DateTime),
+    ADD COLUMN IF NOT EXISTS metadata       Map(String, String),
+    ADD COLUMN IF NOT EXISTS served_at      Nullable(DateTime),
+    ADD COLUMN IF NOT EXISTS position       UInt8,
+    ADD COLUMN IF NOT EXISTS served_price   Float64,
+    ADD COLUMN IF NOT EXISTS keywords       Array(String),
+    ADD COLUMN IF NOT EXISTS categories     Array(LowCardinality(String)),
+    ADD COLUMN IF NOT EXISTS country        LowCardinality(String),
+    ADD COLUMN IF NOT EXISTS region         LowCardinality(String),
+    ADD COLUMN IF NOT EXISTS device_type    LowCardinality(String),
+    ADD COLUMN IF NOT EXISTS ranking_mode   LowCardinality(String),
+    ADD COLUMN IF NOT EXISTS explored       Bool,
+    ADD COLUMN IF NOT EXISTS trace_id       String;
+
+DROP VIEW IF EXISTS mv_kafka_to_ads;
+
 CREATE MATERIALIZED VIEW IF NOT EXISTS mv_kafka_to_ads
 TO ads_final
 AS SELECT
+        JSONExtractUInt(_raw_message, 'schema_version') AS schema_version,
+        JSONExtractString(_raw_message, 'event_id')     AS event_id,
         parseDateTimeBestEffort(JSONExtractString(_raw_message, 'event_time')) AS event_time,
         JSONExtractString(_raw_message, 'event_minute')           AS event_minute,
           JSONExtractString(_raw_message, 'item_id')                   AS item_id,
+          JSONExtractString(_raw_message, 'auction_id')   AS auction_id,
           JSONExtractString(_raw_message, 'user_id')            AS user_id,
           JSONExtractString(_raw_message, 'placement')    AS placement,
           JSONExtractString(_raw_message, 'keyword')      AS keyword,
           JSONExtractUInt(_raw_message, 'clicks')         AS clicks,
           JSONExtractUInt(_raw_message, 'impressions')    AS impressions,
           JSONExtractUInt(_raw_message, 'conversions')    AS conversions,
+          JSONExtractFloat(_raw_message, 'price')         AS price,
+          JSONExtractFloat(_raw_message, 'reported_price') AS reported_price,
+          JSONExtractString(_raw_message, 'experiment_id') AS experiment_id,
+          JSONExtractString(_raw_message, 'variant_id')   AS variant_id,
+          JSONExtractString(_raw_message, 'event_type')   AS event_type,
+          JSONExtractString(_raw_message, 'error_code')   AS error_code,
+          parseDateTimeBestEffortOrNull(JSONExtractString(_raw_message, 'client_time')) AS client_time,
+          JSONExtract(_raw_message, 'metadata', 'Map(String, String)') AS metadata,
+          parseDateTimeBestEffortOrNull(JSONExtractString(_raw_message, 'auction', 'served_at')) AS served_at,
+          JSONExtractUInt(_raw_message, 'auction', 'position') AS position,
+          JSONExtractFloat(_raw_message, 'auction', 'price') AS served_price,
+          JSONExtract(_raw_message, 'auction', 'keywords', 'Array(String)') AS keywords,
+          JSONExtract(_raw_message, 'auction', 'categories', 'Array(String)') AS categories,
+          JSONExtractString(_raw_message, 'auction', 'country') AS country,
+          JSONExtractString(_raw_message, 'auction', 'region') AS region,
+          JSONExtractString(_raw_message, 'auction', 'device_type') AS device_type,
+          JSONExtractString(_raw_message, 'auction', 'ranking_mode') AS ranking_mode,
+          JSONExtractBool(_raw_message, 'auction', 'explored') AS explored,
+          _headers.value[indexOf(_headers.name, 'trace_id')] AS trace_id,
           _raw_message                                    AS message
 FROM kafka_ads;
 
+-- Experiment comparison, CTR and spend of every variant: total_clicks / total_impressions per experiment_id, variant_id
+CREATE TABLE IF NOT EXISTS experiment_report
+(
+    event_time        DateTime,
+    experiment_id     LowCardinality(String),
+    variant_id        LowCardinality(String),
+    placement         LowCardinality(String),
+    total_clicks      UInt64,
+    total_impressions UInt64,
+    total_conversions UInt64,
+    total_spend       Float64
+)
+    ENGINE = SummingMergeTree()
+        ORDER BY (experiment_id, variant_id, placement, event_time);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS mv_ads_final_to_experiment_report
+            TO experiment_report
+AS
+SELECT
+    toStartOfHour(event_time) AS event_time,
+    experiment_id,
+    variant_id,
+    placement,
+    sum(clicks)      AS total_clicks,
+    sum(impressions) AS total_impressions,
+    sum(conversions) AS total_conversions,
+    sum(if(impressions > 0, price / 1000, 0)) AS total_spend
+FROM ads_final
+WHERE experiment_id != ''
+GROUP BY event_time, experiment_id, variant_id, placement;
+
 CREATE TABLE IF NOT EXISTS line_item_report
 (
     event_time       DateTime,
@@ -57,11 +163,17 @@ CREATE TABLE IF NOT EXISTS line_item_report
     keyword          LowCardinality(String), -- even if there are 10K keywords still low, compare to billions (ex. 100B * 20 bytes ≈ 2,000 GB (2 TB just for keywords)) of data
     total_clicks     UInt64,
     total_impressions UInt64,
-    total_conversions UInt64
+    total_conversions UInt64,
+    total_spend      Float64
 )
     ENGINE = SummingMergeTree()
         ORDER BY (event_time);
 
+ALTER TABLE line_item_report
+    ADD COLUMN IF NOT EXISTS total_spend Float64;
+
+DROP VIEW IF EXISTS mv_ads_final_to_line_item_report;
+
 CREATE MATERIALIZED VIEW IF NOT EXISTS mv_ads_final_to_line_item_report
             TO line_item_report
 AS
@@ -73,6 +185,7 @@ SELECT
     item_id,
     sum(clicks)      AS total_clicks,
     sum(impressions) AS total_impressions,
-    sum(conversions) AS total_conversions
+    sum(conversions) AS total_conversions,
+    sum(if(impressions > 0, price / 1000, 0)) AS total_spend
 FROM ads_final
 GROUP BY event_time,event_minute, placement, keyword, item_id;